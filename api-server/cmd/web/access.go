@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// how long the reverse proxy accepts an access token issued to a team member
+const accessTokenTTL = 2 * time.Minute
+
+func validAccessMode(mode string) bool {
+	switch mode {
+	case models.AccessPublic, models.AccessBasic, models.AccessPassword, models.AccessTeam:
+		return true
+	}
+	return false
+}
+
+// siteAccess is the access protection the reverse proxy applies, nil for a public site
+func siteAccess(project models.Project, deploymentAccess string) gin.H {
+	mode := project.AccessMode
+	if deploymentAccess != "" {
+		mode = deploymentAccess
+	}
+	if mode == "" || mode == models.AccessPublic {
+		return nil
+	}
+
+	return gin.H{
+		"mode":         mode,
+		"username":     project.AccessUsername,
+		"passwordHash": project.AccessPasswordHash,
+		"version":      accessVersion(mode, project.AccessPasswordHash),
+	}
+}
+
+// accessVersion changes with the access mode and the password, the proxy drops
+// the sessions signed for another version
+func accessVersion(mode, passwordHash string) string {
+	sum := sha256.Sum256([]byte(mode + "\x00" + passwordHash))
+	return hex.EncodeToString(sum[:8])
+}
+
+// accessClaims are signed with the ACCESS_SIGNING_KEY, which the reverse proxy shares
+type accessClaims struct {
+	ProjectID uint   `json:"p"`
+	Mode      string `json:"m"`
+	Version   string `json:"v"`
+	UserID    uint   `json:"u,omitempty"`
+	ExpiresAt int64  `json:"e"`
+}
+
+func signAccessToken(claims accessClaims) (string, error) {
+	if accessSigningKey == "" {
+		return "", errors.New("ACCESS_SIGNING_KEY is not set")
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, []byte(accessSigningKey))
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// accessSettings validates the access fields of the settings payload and adds the changed columns
+func accessSettings(project models.Project, payload projectSettingsPayload, settings map[string]interface{}) error {
+	if payload.AccessMode == nil && payload.AccessUsername == nil && payload.AccessPassword == nil {
+		return nil
+	}
+
+	mode := project.AccessMode
+	if payload.AccessMode != nil {
+		mode = *payload.AccessMode
+		if !validAccessMode(mode) {
+			return errors.New("accessMode must be public, basic, password or team")
+		}
+		settings["access_mode"] = mode
+	}
+
+	username := project.AccessUsername
+	if payload.AccessUsername != nil {
+		username = strings.TrimSpace(*payload.AccessUsername)
+		if strings.Contains(username, ":") {
+			return errors.New("accessUsername can not contain a colon")
+		}
+		settings["access_username"] = username
+	}
+
+	hasPassword := project.AccessPasswordHash != ""
+	if payload.AccessPassword != nil {
+		if len(*payload.AccessPassword) < 8 {
+			return errors.New("accessPassword must be at least 8 characters")
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(*payload.AccessPassword), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		settings["access_password_hash"] = string(hash)
+		hasPassword = true
+	}
+
+	if mode == models.AccessBasic && (username == "" || !hasPassword) {
+		return errors.New("basic access needs accessUsername and accessPassword")
+	}
+	if mode == models.AccessPassword && !hasPassword {
+		return errors.New("password access needs accessPassword")
+	}
+
+	return nil
+}
+
+type deploymentAccessPayload struct {
+	AccessMode string `json:"accessMode"`
+}
+
+// override the access mode of the project for a deployment, an empty mode inherits it again
+func (app *app) deploymentAccessHandler(ctx *gin.Context) {
+	deploymentID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Deployment does not exist",
+		})
+		return
+	}
+
+	deployment, err := app.deploymentController.Get(deploymentID)
+	if err == models.ErrNoRecord {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Deployment does not exist",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the deployment using ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	if deployment.Project.UserID != app.loggedInUserID(ctx) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"response": "User not authorized",
+		})
+		return
+	}
+
+	payload := deploymentAccessPayload{}
+	err = json.NewDecoder(ctx.Request.Body).Decode(&payload)
+	if err != nil {
+		app.errorLogger.Println("Unable to decode the payload JSON.", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "Not a valid request payload.",
+		})
+		return
+	}
+
+	project := deployment.Project
+	if err := app.pathRoutingSettings(projectSettingsPayload{AccessMode: &payload.AccessMode}); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": err.Error(),
+		})
+		return
+	}
+	switch {
+	case payload.AccessMode != "" && !validAccessMode(payload.AccessMode):
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "accessMode must be empty, public, basic, password or team",
+		})
+		return
+	case payload.AccessMode == models.AccessBasic && (project.AccessUsername == "" || project.AccessPasswordHash == ""):
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "basic access needs the accessUsername and accessPassword of the project",
+		})
+		return
+	case payload.AccessMode == models.AccessPassword && project.AccessPasswordHash == "":
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "password access needs the accessPassword of the project",
+		})
+		return
+	}
+
+	err = app.deploymentController.SetAccessMode(deployment.ID, payload.AccessMode)
+	if err != nil {
+		app.errorLogger.Println("Unable to update the access mode of the deployment.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	if project.LiveDeploymentID == deployment.ID || project.CanaryDeploymentID == deployment.ID {
+		app.publishRouteChange(project.ID, "access")
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"response": "Deployment access updated.",
+	})
+}
+
+// authorize a logged in user to see a team protected site: the reverse proxy sends the browser here
+// and gets it back on the site with a short lived token, which it exchanges for a session cookie
+func (app *app) authorizeAccessHandler(ctx *gin.Context) {
+	projectID, err := strconv.Atoi(ctx.Query("project"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Project Id does not exist",
+		})
+		return
+	}
+
+	project, err := app.projectModel.CheckExistingProject(projectID)
+	if err == models.ErrNoRecord {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Project Id does not exist",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the project using ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	userID := app.loggedInUserID(ctx)
+	if project.UserID != userID {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"response": "User has no access to the project",
+		})
+		return
+	}
+
+	// only hand the token to a site of the project, never to somewhere else
+	redirect, err := url.Parse(ctx.Query("redirect"))
+	if err != nil || (redirect.Scheme != "http" && redirect.Scheme != "https") {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "redirect must be a URL of the project site",
+		})
+		return
+	}
+	prefix, path, previewID, ok := app.siteLocation(project, redirect)
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "redirect must be a URL of the project site",
+		})
+		return
+	}
+
+	// the token is only valid on a deployment protected by the team, the preview of the redirect
+	// or the live deployment and its canary which share the hosts of the project
+	served := []uint{project.LiveDeploymentID, project.CanaryDeploymentID}
+	deploymentAccess := map[uint]string{}
+	if previewID != 0 {
+		deployment, err := app.deploymentController.Get(int(previewID))
+		if err == models.ErrNoRecord || (err == nil && deployment.ProjectID != project.ID) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "redirect must be a URL of the project site",
+			})
+			return
+		} else if err != nil {
+			app.errorLogger.Println("unable to query the deployment using ID", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"response": "Internal Server Error",
+			})
+			return
+		}
+		served = []uint{deployment.ID}
+		deploymentAccess[deployment.ID] = deployment.AccessMode
+	} else {
+		deploymentAccess, err = app.deploymentController.AccessModes(served)
+		if err != nil {
+			app.errorLogger.Println("unable to query the access mode of the deployment", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"response": "Internal Server Error",
+			})
+			return
+		}
+	}
+	teamProtected := false
+	for _, deploymentID := range served {
+		if deploymentID == 0 {
+			continue
+		}
+		mode := project.AccessMode
+		if deploymentAccess[deploymentID] != "" {
+			mode = deploymentAccess[deploymentID]
+		}
+		teamProtected = teamProtected || mode == models.AccessTeam
+	}
+	if !teamProtected {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "The site is not protected by team access",
+		})
+		return
+	}
+
+	// the version of a team protected deployment, whichever one of them the visitor is served
+	token, err := signAccessToken(accessClaims{
+		ProjectID: project.ID,
+		Mode:      models.AccessTeam,
+		Version:   accessVersion(models.AccessTeam, project.AccessPasswordHash),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(accessTokenTTL).Unix(),
+	})
+	if err != nil {
+		app.errorLogger.Println("Unable to sign the access token.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	callback := url.URL{
+		Scheme: redirect.Scheme,
+		Host:   redirect.Host,
+		Path:   prefix + "/__scale-mesh/callback",
+		RawQuery: url.Values{
+			"token":    {token},
+			"redirect": {path},
+		}.Encode(),
+	}
+	ctx.Redirect(http.StatusFound, callback.String())
+}
+
+// siteLocation checks the URL is on a site of the project: its slug or preview host under the sites domain,
+// its /{label} path with the path-based routing, or a verified custom domain. prefix is the /{label}
+// of the path-based routing, path the request URI as the site sees it and previewID the deployment
+// of a preview label, 0 for the hosts of the project itself
+func (app *app) siteLocation(project models.Project, target *url.URL) (prefix, path string, previewID uint, ok bool) {
+	host := strings.ToLower(target.Host)
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	sitesDomain := strings.ToLower(app.config.sitesDomain)
+	if hostname, _, err := net.SplitHostPort(sitesDomain); err == nil {
+		sitesDomain = hostname
+	}
+
+	if host == sitesDomain && app.config.sitesRouting == "path" {
+		label, rest, _ := strings.Cut(strings.TrimPrefix(target.EscapedPath(), "/"), "/")
+		previewID, ok = projectLabel(project, strings.ToLower(label))
+		if !ok {
+			return "", "", 0, false
+		}
+		path = "/" + rest
+		if target.RawQuery != "" {
+			path += "?" + target.RawQuery
+		}
+		return "/" + label, path, previewID, true
+	}
+
+	if label, found := strings.CutSuffix(host, "."+sitesDomain); found {
+		previewID, ok = projectLabel(project, label)
+		return "", target.RequestURI(), previewID, ok
+	}
+
+	domain, err := app.domainController.GetVerifiedByHostname(host)
+	return "", target.RequestURI(), 0, err == nil && domain.ProjectID == project.ID
+}
+
+// projectLabel reports the slug of the project, or the {deploymentID}--{slug} of one of its previews
+// with the ID of the deployment
+func projectLabel(project models.Project, label string) (previewID uint, ok bool) {
+	if label == project.Slug {
+		return 0, true
+	}
+	deploymentID, slug, found := strings.Cut(label, "--")
+	if !found || slug != project.Slug {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(deploymentID, 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+)
+
+const (
+	// longest time range of an analytics query
+	maxAnalyticsRange = 93 * 24 * time.Hour
+	defaultTopPaths   = 10
+	maxTopPaths       = 100
+)
+
+// trafficPayload is what the reverse proxies aggregate from their access logs between two flushes
+type trafficPayload struct {
+	Stats []struct {
+		ProjectID uint             `json:"projectId"`
+		Hour      time.Time        `json:"hour"`
+		Requests  int64            `json:"requests"`
+		Bytes     int64            `json:"bytes"`
+		Status2xx int64            `json:"status2xx"`
+		Status3xx int64            `json:"status3xx"`
+		Status4xx int64            `json:"status4xx"`
+		Status5xx int64            `json:"status5xx"`
+		LatencyMs int64            `json:"latencyMs"`
+		Paths     map[string]int64 `json:"paths"`
+	} `json:"stats"`
+}
+
+// reverse proxies add the traffic counters of the projects
+func (app *app) recordTrafficHandler(ctx *gin.Context) {
+	payload := trafficPayload{}
+	err := json.NewDecoder(ctx.Request.Body).Decode(&payload)
+	if err != nil {
+		app.errorLogger.Println("Unable to decode the payload JSON.", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "Not a valid request payload.",
+		})
+		return
+	}
+
+	stats := []models.TrafficStat{}
+	paths := []models.TrafficPath{}
+	for _, stat := range payload.Stats {
+		if stat.ProjectID == 0 {
+			continue
+		}
+		hour := stat.Hour.UTC().Truncate(time.Hour)
+		stats = append(stats, models.TrafficStat{
+			ProjectID: stat.ProjectID,
+			Hour:      hour,
+			Requests:  stat.Requests,
+			Bytes:     stat.Bytes,
+			Status2xx: stat.Status2xx,
+			Status3xx: stat.Status3xx,
+			Status4xx: stat.Status4xx,
+			Status5xx: stat.Status5xx,
+			LatencyMs: stat.LatencyMs,
+		})
+		for path, requests := range stat.Paths {
+			paths = append(paths, models.TrafficPath{
+				ProjectID: stat.ProjectID,
+				Hour:      hour,
+				Path:      path,
+				Requests:  requests,
+			})
+		}
+	}
+
+	err = app.trafficController.Record(stats, paths)
+	if err != nil {
+		app.errorLogger.Println("Unable to record the traffic of the projects.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"response": "Traffic recorded.",
+	})
+}
+
+// traffic of a project over ?from=&to= (RFC 3339, the last 24 hours by default), counted per hour
+func (app *app) projectAnalyticsHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	to := time.Now().UTC()
+	if value := ctx.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "to must be an RFC 3339 time",
+			})
+			return
+		}
+		to = parsed.UTC()
+	}
+	from := to.Add(-24 * time.Hour)
+	if value := ctx.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "from must be an RFC 3339 time",
+			})
+			return
+		}
+		from = parsed.UTC()
+	}
+	if !from.Before(to) || to.Sub(from) > maxAnalyticsRange {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "from must be before to, at most 93 days apart",
+		})
+		return
+	}
+
+	topPaths := defaultTopPaths
+	if value := ctx.Query("paths"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxTopPaths {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "paths must be between 1 and 100",
+			})
+			return
+		}
+		topPaths = parsed
+	}
+
+	// whole hours, the hour of from is included
+	from = from.Truncate(time.Hour)
+
+	stats, err := app.trafficController.ListStats(project.ID, from, to)
+	if err != nil {
+		app.errorLogger.Println("unable to list the traffic of the project", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	paths, err := app.trafficController.TopPaths(project.ID, from, to, topPaths)
+	if err != nil {
+		app.errorLogger.Println("unable to list the top paths of the project", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	total := models.TrafficStat{}
+	series := []gin.H{}
+	for _, stat := range stats {
+		total.Requests += stat.Requests
+		total.Bytes += stat.Bytes
+		total.Status2xx += stat.Status2xx
+		total.Status3xx += stat.Status3xx
+		total.Status4xx += stat.Status4xx
+		total.Status5xx += stat.Status5xx
+		total.LatencyMs += stat.LatencyMs
+		series = append(series, trafficCounters(stat, gin.H{"hour": stat.Hour}))
+	}
+	if paths == nil {
+		paths = []models.PathRequests{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"from":     from,
+		"to":       to,
+		"totals":   trafficCounters(total, gin.H{}),
+		"series":   series,
+		"topPaths": paths,
+	})
+}
+
+// trafficCounters adds the counters of the stat to the response
+func trafficCounters(stat models.TrafficStat, response gin.H) gin.H {
+	response["requests"] = stat.Requests
+	response["bytes"] = stat.Bytes
+	response["status"] = gin.H{
+		"2xx": stat.Status2xx,
+		"3xx": stat.Status3xx,
+		"4xx": stat.Status4xx,
+		"5xx": stat.Status5xx,
+	}
+	response["avgLatencyMs"] = 0.0
+	if stat.Requests > 0 {
+		response["avgLatencyMs"] = float64(stat.LatencyMs) / float64(stat.Requests)
+	}
+
+	return response
+}
+
+// pruneAnalytics deletes the traffic counters older than retention every hour
+func (app *app) pruneAnalytics(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := app.trafficController.DeleteBefore(time.Now().Add(-retention))
+			if err != nil {
+				app.errorLogger.Println("unable to delete the expired traffic counters", err)
+			} else if deleted > 0 {
+				app.infoLogger.Printf("deleted %d hours of expired traffic counters", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+)
+
+// the app runner answers a start once the app is healthy, which may take a while
+var runnerClient = &http.Client{Timeout: 3 * time.Minute}
+
+// how long the app runner may take to download the archive of an app deployment
+const appArchiveURLTTL = 15 * time.Minute
+
+func validRuntime(runtime string) bool {
+	return runtime == models.RuntimeStatic || runtime == models.RuntimeApp
+}
+
+// startAppPayload is what the app runner needs to run a deployment
+type startAppPayload struct {
+	ProjectID       string `json:"projectId"`
+	ArchiveURL      string `json:"archiveUrl"`
+	StartCommand    string `json:"startCommand"`
+	HealthCheckPath string `json:"healthCheckPath"`
+}
+
+// runApp starts the app of the deployment on the app runner and waits until it is healthy,
+// a running app is left as it is
+func (app *app) runApp(project models.Project, deploymentID uint) error {
+	archiveURL, err := app.storage.PresignAppArchive(context.Background(), deploymentID, appArchiveURLTTL)
+	if err != nil {
+		return err
+	}
+
+	payload := startAppPayload{
+		ProjectID:       strconv.FormatUint(uint64(project.ID), 10),
+		ArchiveURL:      archiveURL,
+		StartCommand:    project.StartCommand,
+		HealthCheckPath: project.HealthCheckPath,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return app.callRunner(http.MethodPut, deploymentID, strings.NewReader(string(body)))
+}
+
+// stopApp stops the app of a deployment which isn't served anymore, static deployments have none
+func (app *app) stopApp(project models.Project, deploymentID uint) {
+	if project.Runtime != models.RuntimeApp || deploymentID == 0 {
+		return
+	}
+
+	go func() {
+		err := app.callRunner(http.MethodDelete, deploymentID, nil)
+		if err != nil {
+			app.errorLogger.Printf("unable to stop the app of deployment %d, %s", deploymentID, err)
+		}
+	}()
+}
+
+func (app *app) callRunner(method string, deploymentID uint, body io.Reader) error {
+	url := fmt.Sprintf("%s/apps/%d", strings.TrimSuffix(app.config.appRunnerURL, "/"), deploymentID)
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Internal-Token", internalToken)
+
+	response, err := runnerClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusOK || (method == http.MethodDelete && response.StatusCode == http.StatusNotFound) {
+		return nil
+	}
+
+	// the runner tells why the app didn't start
+	failure := struct {
+		Error string `json:"error"`
+	}{}
+	json.NewDecoder(io.LimitReader(response.Body, 64<<10)).Decode(&failure)
+	if failure.Error != "" {
+		return errors.New(failure.Error)
+	}
+	return fmt.Errorf("app runner responded with %s", response.Status)
+}
+
+// startApp runs the app of a ready deployment and serves it once it is healthy,
+// a deployment whose app doesn't come up fails
+func (app *app) startApp(deployment models.Deployment) {
+	err := app.runApp(deployment.Project, deployment.ID)
+	if err != nil {
+		app.errorLogger.Printf("unable to start the app of deployment %d, %s", deployment.ID, err)
+		err = app.deploymentController.Fail(deployment.ID, "the app did not start, "+err.Error())
+		if err != nil {
+			app.errorLogger.Println("Unable to update the deployment status.", err)
+		}
+		return
+	}
+
+	err = app.releaseDeployment(deployment)
+	if err != nil {
+		app.errorLogger.Printf("unable to serve the app of deployment %d, %s", deployment.ID, err)
+		app.stopApp(deployment.Project, deployment.ID)
+	}
+}
+
+// releaseDeployment serves a ready deployment: as the canary when it asks for one and the project
+// has a live deployment to split with, otherwise as the live deployment. The app it replaces is stopped
+func (app *app) releaseDeployment(deployment models.Deployment) error {
+	project := deployment.Project
+
+	if deployment.CanaryWeight > 0 && project.LiveDeploymentID != 0 {
+		err := app.projectModel.SetCanary(project.ID, deployment.ID, deployment.CanaryWeight)
+		if err != nil {
+			return err
+		}
+		app.infoLogger.Printf("deployment %d is the canary of project %d for %d%% of the visitors", deployment.ID, project.ID, deployment.CanaryWeight)
+		app.publishRouteChange(project.ID, "canary")
+
+		if project.CanaryDeploymentID != deployment.ID {
+			app.stopApp(project, project.CanaryDeploymentID)
+		}
+		return nil
+	}
+
+	err := app.projectModel.SetLiveDeployment(project.ID, deployment.ID)
+	if err != nil {
+		return err
+	}
+	app.infoLogger.Printf("deployment %d is live for project %d", deployment.ID, project.ID)
+	app.publishRouteChange(project.ID, "promote")
+
+	// the new deployment replaces the live one and the canary
+	if project.LiveDeploymentID != deployment.ID {
+		app.stopApp(project, project.LiveDeploymentID)
+	}
+	if project.CanaryDeploymentID != 0 && project.CanaryDeploymentID != deployment.ID {
+		app.infoLogger.Printf("the canary deployment %d of project %d ended", project.CanaryDeploymentID, project.ID)
+		app.stopApp(project, project.CanaryDeploymentID)
+	}
+	return nil
+}
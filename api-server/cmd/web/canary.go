@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+)
+
+type canaryPayload struct {
+	DeploymentID uint `json:"deploymentId"`
+	Weight       *int `json:"weight"`
+}
+
+func validCanaryWeight(weight int) bool {
+	return weight >= 0 && weight <= 100
+}
+
+// canaryResponse is the traffic split of the project
+func canaryResponse(project models.Project) gin.H {
+	response := gin.H{
+		"liveDeploymentId": project.LiveDeploymentID,
+		"canary":           nil,
+	}
+	if project.CanaryDeploymentID != 0 {
+		response["canary"] = gin.H{
+			"deploymentId": project.CanaryDeploymentID,
+			"weight":       project.CanaryWeight,
+		}
+	}
+
+	return response
+}
+
+// the traffic split between the live deployment and the canary of the project
+func (app *app) canaryHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, canaryResponse(project))
+}
+
+// start a canary: serve a ready deployment of the project to weight percent of its visitors
+func (app *app) startCanaryHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	payload := canaryPayload{}
+	err := json.NewDecoder(ctx.Request.Body).Decode(&payload)
+	if err != nil || payload.Weight == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "Send the deploymentId and the weight of the canary.",
+		})
+		return
+	}
+	if !validCanaryWeight(*payload.Weight) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "weight must be a percentage between 0 and 100",
+		})
+		return
+	}
+
+	if project.LiveDeploymentID == 0 {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"response": "Project has no live deployment to split the traffic with",
+		})
+		return
+	}
+
+	deployment, err := app.deploymentController.Get(int(payload.DeploymentID))
+	if err == models.ErrNoRecord || (err == nil && deployment.ProjectID != project.ID) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Deployment does not exist",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the deployment using ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	switch {
+	case deployment.ID == project.LiveDeploymentID:
+		ctx.JSON(http.StatusConflict, gin.H{
+			"response": "Deployment is already live",
+		})
+		return
+	case deployment.Status != models.READY:
+		ctx.JSON(http.StatusConflict, gin.H{
+			"response": "Deployment is not ready, current status " + deployment.Status.String(),
+		})
+		return
+	case deployment.ArtifactsDeletedAt != nil:
+		ctx.JSON(http.StatusConflict, gin.H{
+			"response": "Artifacts of the deployment were deleted by the retention policy",
+		})
+		return
+	}
+
+	// the app of an older deployment isn't running anymore
+	if project.Runtime == models.RuntimeApp {
+		err = app.runApp(project, deployment.ID)
+		if err != nil {
+			app.errorLogger.Printf("unable to start the app of deployment %d, %s", deployment.ID, err)
+			ctx.JSON(http.StatusBadGateway, gin.H{
+				"response": "The app of the deployment did not start, " + err.Error(),
+			})
+			return
+		}
+	}
+
+	err = app.projectModel.SetCanary(project.ID, deployment.ID, *payload.Weight)
+	if err != nil {
+		app.errorLogger.Println("Unable to start the canary.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+	app.publishRouteChange(project.ID, "canary")
+	if project.CanaryDeploymentID != deployment.ID {
+		app.stopApp(project, project.CanaryDeploymentID)
+	}
+
+	project.CanaryDeploymentID = deployment.ID
+	project.CanaryWeight = *payload.Weight
+	ctx.JSON(http.StatusOK, canaryResponse(project))
+}
+
+// change the percentage of the visitors served by the canary
+func (app *app) canaryWeightHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	if project.CanaryDeploymentID == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Project has no canary",
+		})
+		return
+	}
+
+	payload := canaryPayload{}
+	err := json.NewDecoder(ctx.Request.Body).Decode(&payload)
+	if err != nil || payload.Weight == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "Send the weight of the canary.",
+		})
+		return
+	}
+	if !validCanaryWeight(*payload.Weight) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "weight must be a percentage between 0 and 100",
+		})
+		return
+	}
+
+	err = app.projectModel.SetCanary(project.ID, project.CanaryDeploymentID, *payload.Weight)
+	if err != nil {
+		app.errorLogger.Println("Unable to update the canary weight.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+	app.publishRouteChange(project.ID, "canary")
+
+	project.CanaryWeight = *payload.Weight
+	ctx.JSON(http.StatusOK, canaryResponse(project))
+}
+
+// make the canary the live deployment for every visitor
+func (app *app) promoteCanaryHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	err := app.projectModel.PromoteCanary(project.ID)
+	if err == models.ErrNoRecord {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Project has no canary",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("Unable to promote the canary.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+	app.infoLogger.Printf("canary deployment %d is live for project %d", project.CanaryDeploymentID, project.ID)
+	app.publishRouteChange(project.ID, "promote")
+	app.stopApp(project, project.LiveDeploymentID)
+
+	project.LiveDeploymentID = project.CanaryDeploymentID
+	project.CanaryDeploymentID = 0
+	project.CanaryWeight = 0
+	ctx.JSON(http.StatusOK, canaryResponse(project))
+}
+
+// stop the canary, every visitor is served the live deployment again
+func (app *app) abortCanaryHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	if project.CanaryDeploymentID == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Project has no canary",
+		})
+		return
+	}
+
+	err := app.projectModel.SetCanary(project.ID, 0, 0)
+	if err != nil {
+		app.errorLogger.Println("Unable to abort the canary.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+	app.publishRouteChange(project.ID, "canary")
+	app.stopApp(project, project.CanaryDeploymentID)
+
+	project.CanaryDeploymentID = 0
+	project.CanaryWeight = 0
+	ctx.JSON(http.StatusOK, canaryResponse(project))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+)
+
+// previewable reports whether the preview host of the deployment can answer: the app runner only runs
+// the apps of the live deployment and the canary, a static deployment is served from its artifacts
+func previewable(deployment models.Deployment, project models.Project) bool {
+	return project.Runtime != models.RuntimeApp || deployment.ID == project.LiveDeploymentID || deployment.ID == project.CanaryDeploymentID
+}
+
+// deploymentResponse describes a deployment with the URL serving its own artifacts
+func (app *app) deploymentResponse(deployment models.Deployment, project models.Project) gin.H {
+	response := gin.H{
+		"id":                 deployment.ID,
+		"projectId":          deployment.ProjectID,
+		"status":             deployment.Status.String(),
+		"message":            deployment.Message,
+		"createdAt":          deployment.CreatedAt,
+		"totalFiles":         deployment.TotalFiles,
+		"totalSize":          deployment.TotalSize,
+		"live":               deployment.ID == project.LiveDeploymentID,
+		"canary":             deployment.ID == project.CanaryDeploymentID,
+		"accessMode":         deployment.AccessMode,
+		"artifactsDeletedAt": deployment.ArtifactsDeletedAt,
+	}
+	if previewable(deployment, project) {
+		response["previewUrl"] = app.previewURL(deployment.ID, project.Slug)
+	}
+
+	return response
+}
+
+// get a deployment of the user
+func (app *app) getDeploymentHandler(ctx *gin.Context) {
+	deploymentID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Deployment does not exist",
+		})
+		return
+	}
+
+	deployment, err := app.deploymentController.Get(deploymentID)
+	if err == models.ErrNoRecord {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Deployment does not exist",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the deployment using ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	if deployment.Project.UserID != app.loggedInUserID(ctx) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"response": "User not authorized",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, app.deploymentResponse(deployment, deployment.Project))
+}
+
+// list the deployments of a project, newest first
+func (app *app) listDeploymentsHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	deployments, err := app.deploymentController.ListByProject(project.ID)
+	if err != nil {
+		app.errorLogger.Println("unable to list the deployments of the project", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	response := []gin.H{}
+	for _, deployment := range deployments {
+		response = append(response, app.deploymentResponse(deployment, project))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"deployments": response,
+	})
+}
+
+// reverse proxy looks up a {deploymentID}--{slug}.{baseDomain} host, the route of a ready
+// deployment whose artifacts still exist, with the access mode of the deployment. The app
+// of a deployment which is neither live nor the canary isn't running, its preview is not found
+func (app *app) previewRouteHandler(ctx *gin.Context) {
+	deploymentID, err := strconv.Atoi(ctx.Param("deployment"))
+	if err != nil {
+		app.notFound(ctx.Writer)
+		return
+	}
+
+	deployment, err := app.deploymentController.Get(deploymentID)
+	if err == models.ErrNoRecord {
+		app.notFound(ctx.Writer)
+		return
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the deployment using ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	project := deployment.Project
+	if project.Slug != ctx.Param("slug") || deployment.Status != models.READY || deployment.ArtifactsDeletedAt != nil || !previewable(deployment, project) {
+		app.notFound(ctx.Writer)
+		return
+	}
+
+	// the preview host serves the deployment alone, as if it were live without a canary
+	project.LiveDeploymentID = deployment.ID
+	project.CanaryDeploymentID = 0
+	route := projectRoute(project, map[uint]string{deployment.ID: deployment.AccessMode})
+	route["preview"] = true
+
+	ctx.JSON(http.StatusOK, route)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+)
+
+var hostnameLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// normalizeHostname lowercases the hostname and checks it is a valid DNS name with at least two labels
+func normalizeHostname(hostname string) (string, bool) {
+	hostname = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")
+	if len(hostname) == 0 || len(hostname) > 253 || net.ParseIP(hostname) != nil {
+		return "", false
+	}
+
+	labels := strings.Split(hostname, ".")
+	if len(labels) < 2 {
+		return "", false
+	}
+	for _, label := range labels {
+		if !hostnameLabel.MatchString(label) {
+			return "", false
+		}
+	}
+
+	return hostname, true
+}
+
+type domainPayload struct {
+	Hostname string `json:"hostname"`
+}
+
+// attach a custom hostname to a project
+func (app *app) attachDomainHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	payload := domainPayload{}
+	err := json.NewDecoder(ctx.Request.Body).Decode(&payload)
+	if err != nil {
+		app.errorLogger.Println("Unable to decode the payload JSON.", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "Not a valid request payload.",
+		})
+		return
+	}
+
+	hostname, ok := normalizeHostname(payload.Hostname)
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "Not a valid hostname.",
+		})
+		return
+	}
+
+	token, err := newVerificationToken()
+	if err != nil {
+		app.errorLogger.Println("Unable to create the verification token.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	now := time.Now()
+	domain, err := app.domainController.Insert(models.Domain{
+		Hostname:              hostname,
+		ProjectID:             project.ID,
+		Status:                models.DomainPending,
+		VerificationToken:     token,
+		VerificationStartedAt: &now,
+	})
+	if err == models.ErrDuplicateDomain {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"response": "Domain is already attached to a project.",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("Unable to attach the domain.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	// the domain is routed and can become the primary one once it is verified
+	response := domainResponse(domain, project)
+	response["response"] = "Domain attached, create the TXT record to verify the ownership."
+	ctx.JSON(http.StatusOK, response)
+}
+
+// list the custom hostnames of a project
+func (app *app) listDomainsHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	domains, err := app.domainController.ListByProject(project.ID)
+	if err != nil {
+		app.errorLogger.Println("Unable to list the domains.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	hostnames := []gin.H{}
+	for _, domain := range domains {
+		hostnames = append(hostnames, domainResponse(domain, project))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"domains": hostnames,
+	})
+}
+
+// detach a custom hostname from a project
+func (app *app) detachDomainHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	hostname, _ := normalizeHostname(ctx.Param("hostname"))
+	err := app.domainController.Delete(project.ID, hostname)
+	if err == models.ErrNoRecord {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Domain is not attached to the project.",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("Unable to detach the domain.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	app.publishRouteChange(project.ID, "domain", hostname)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"response": "Domain detached.",
+	})
+}
+
+// reverse proxy looks up which project serves a verified custom hostname
+func (app *app) hostRouteHandler(ctx *gin.Context) {
+	hostname, ok := normalizeHostname(ctx.Param("host"))
+	if !ok {
+		app.notFound(ctx.Writer)
+		return
+	}
+
+	domain, err := app.domainController.GetVerifiedByHostname(hostname)
+	if err == models.ErrNoRecord {
+		app.notFound(ctx.Writer)
+		return
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the domain using hostname", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	app.respondRoute(ctx, domain.Project)
+}
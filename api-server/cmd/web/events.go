@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+)
+
+// routeChange is published on the routes channel whenever the routing of a project changes,
+// reverse proxies replace the routes of the project's hosts with it
+type routeChange struct {
+	Reason    string `json:"reason"`
+	ProjectID uint   `json:"projectId"`
+	Slug      string `json:"slug"`
+	// verified custom domains of the project
+	Hosts []string `json:"hosts"`
+	// slugs and hostnames which don't route to the project anymore
+	Removed []string `json:"removed,omitempty"`
+	// nil when the project has no live deployment
+	Route gin.H `json:"route"`
+}
+
+// routes of a project as the reverse proxy keys them, hosts are its verified custom domains
+// and deploymentAccess the access mode overrides of its live and canary deployments
+func newRouteChange(project models.Project, hosts []string, deploymentAccess map[uint]string, reason string) routeChange {
+	change := routeChange{
+		Reason:    reason,
+		ProjectID: project.ID,
+		Slug:      project.Slug,
+		Hosts:     hosts,
+	}
+	if change.Hosts == nil {
+		change.Hosts = []string{}
+	}
+	if project.LiveDeploymentID != 0 {
+		change.Route = projectRoute(project, deploymentAccess)
+	}
+
+	return change
+}
+
+// publishRouteChange tells the reverse proxies the current routing of the project,
+// removed lists the slugs and hostnames the project stopped answering.
+// Publishing is best effort, a proxy which misses the event picks it up on its next resync
+func (app *app) publishRouteChange(projectID uint, reason string, removed ...string) {
+	if app.redis == nil {
+		return
+	}
+
+	project, err := app.projectModel.CheckExistingProject(int(projectID))
+	if err != nil {
+		app.errorLogger.Println("unable to query the project of the route change", err)
+		return
+	}
+
+	domains, err := app.domainController.ListByProject(project.ID)
+	if err != nil {
+		app.errorLogger.Println("unable to list the hosts of the route change", err)
+		return
+	}
+	hosts := []string{}
+	for _, domain := range domains {
+		if domain.Status == models.DomainVerified {
+			hosts = append(hosts, domain.Hostname)
+		}
+	}
+
+	deploymentAccess, err := app.deploymentController.AccessModes([]uint{project.LiveDeploymentID, project.CanaryDeploymentID})
+	if err != nil {
+		app.errorLogger.Println("unable to query the access mode of the live deployment", err)
+		return
+	}
+
+	change := newRouteChange(project, hosts, deploymentAccess, reason)
+	change.Removed = removed
+
+	payload, err := json.Marshal(change)
+	if err != nil {
+		app.errorLogger.Println("unable to encode the route change", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = app.redis.Publish(ctx, app.config.routesChannel, payload).Err()
+	if err != nil {
+		app.errorLogger.Println("unable to publish the route change", err)
+	}
+}
+
+// reverse proxies resync their route tables with the routes of every live project
+func (app *app) routesHandler(ctx *gin.Context) {
+	projects, err := app.projectModel.ListLive()
+	if err != nil {
+		app.errorLogger.Println("unable to list the live projects", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	domains, err := app.domainController.ListVerified()
+	if err != nil {
+		app.errorLogger.Println("unable to list the verified domains", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+	hosts := map[uint][]string{}
+	for _, domain := range domains {
+		hosts[domain.ProjectID] = append(hosts[domain.ProjectID], domain.Hostname)
+	}
+
+	servedDeployments := make([]uint, 0, len(projects))
+	for _, project := range projects {
+		servedDeployments = append(servedDeployments, project.LiveDeploymentID)
+		if project.CanaryDeploymentID != 0 {
+			servedDeployments = append(servedDeployments, project.CanaryDeploymentID)
+		}
+	}
+	deploymentAccess, err := app.deploymentController.AccessModes(servedDeployments)
+	if err != nil {
+		app.errorLogger.Println("unable to query the access modes of the live deployments", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	routes := []routeChange{}
+	for _, project := range projects {
+		routes = append(routes, newRouteChange(project, hosts[project.ID], deploymentAccess, "resync"))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"routes": routes,
+	})
+}
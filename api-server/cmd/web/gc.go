@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+)
+
+// retentionPolicy decides which deployments of a project keep their artifacts
+type retentionPolicy struct {
+	keepDeployments int
+	keepDays        int
+}
+
+// policy of the project, falling back to the platform defaults
+func (app *app) retentionPolicy(project models.Project) retentionPolicy {
+	policy := retentionPolicy{
+		keepDeployments: app.config.keepDeployments,
+		keepDays:        app.config.keepDays,
+	}
+	if project.KeepDeployments > 0 {
+		policy.keepDeployments = project.KeepDeployments
+	}
+	if project.KeepDays > 0 {
+		policy.keepDays = project.KeepDays
+	}
+
+	return policy
+}
+
+// expiredDeployments returns the deployments whose artifacts can be deleted: the live and canary deployments,
+// the last keepDeployments ready deployments, anything newer than keepDays and builds still running are kept.
+func expiredDeployments(project models.Project, deployments []models.Deployment, policy retentionPolicy, now time.Time) []models.Deployment {
+	sorted := make([]models.Deployment, len(deployments))
+	copy(sorted, deployments)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	cutoff := now.AddDate(0, 0, -policy.keepDays)
+	expired := []models.Deployment{}
+	ready := 0
+	for _, deployment := range sorted {
+		keep := false
+		switch {
+		case deployment.ID == project.LiveDeploymentID || deployment.ID == project.CanaryDeploymentID:
+			keep = true
+		case deployment.Status == models.QUEUE || deployment.Status == models.PROGRESS:
+			keep = true
+		case deployment.Status == models.READY && ready < policy.keepDeployments:
+			keep = true
+		case deployment.CreatedAt.After(cutoff):
+			keep = true
+		}
+
+		if deployment.Status == models.READY {
+			ready++
+		}
+		if !keep {
+			expired = append(expired, deployment)
+		}
+	}
+
+	return expired
+}
+
+type collectedDeployment struct {
+	DeploymentID   uint      `json:"deploymentId"`
+	ProjectID      uint      `json:"projectId"`
+	CreatedAt      time.Time `json:"createdAt"`
+	DeletedObjects int       `json:"deletedObjects"`
+}
+
+// delete the expired artifacts of a project, in dry run mode only list them
+func (app *app) collectProjectGarbage(ctx context.Context, project models.Project, dryRun bool) ([]collectedDeployment, error) {
+	deployments, err := app.deploymentController.ListWithArtifacts(project.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	collected := []collectedDeployment{}
+	for _, deployment := range expiredDeployments(project, deployments, app.retentionPolicy(project), time.Now()) {
+		entry := collectedDeployment{
+			DeploymentID: deployment.ID,
+			ProjectID:    project.ID,
+			CreatedAt:    deployment.CreatedAt,
+		}
+
+		if dryRun {
+			app.infoLogger.Printf("GC dry run: would delete the artifacts of deployment %d of project %d", deployment.ID, project.ID)
+			collected = append(collected, entry)
+			continue
+		}
+
+		deleted, err := app.storage.DeleteDeployment(ctx, deployment.ID)
+		if err != nil {
+			return collected, err
+		}
+		err = app.deploymentController.MarkArtifactsDeleted(deployment.ID)
+		if err != nil {
+			return collected, err
+		}
+
+		entry.DeletedObjects = deleted
+		app.infoLogger.Printf("GC: deleted %d objects of deployment %d of project %d", deleted, deployment.ID, project.ID)
+		collected = append(collected, entry)
+	}
+
+	return collected, nil
+}
+
+// run the garbage collector over every project
+func (app *app) collectGarbage(ctx context.Context, dryRun bool) {
+	projects, err := app.projectModel.All()
+	if err != nil {
+		app.errorLogger.Println("GC: unable to list the projects", err)
+		return
+	}
+
+	total := 0
+	for _, project := range projects {
+		collected, err := app.collectProjectGarbage(ctx, project, dryRun)
+		if err != nil {
+			app.errorLogger.Printf("GC: unable to collect the artifacts of project %d, %s", project.ID, err)
+		}
+		total += len(collected)
+	}
+
+	app.infoLogger.Printf("GC: finished, %d deployments collected (dry run: %t)", total, dryRun)
+}
+
+// run the garbage collector every interval until the context is done
+func (app *app) runGarbageCollector(ctx context.Context, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.collectGarbage(ctx, dryRun)
+		}
+	}
+}
@@ -8,13 +8,16 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 	"github.com/aws/smithy-go"
 	"github.com/gin-gonic/gin"
 	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/storage"
 )
 
 // Health Check endpoint handler
@@ -68,10 +71,72 @@ func (app *app) deploymentHandler(ctx *gin.Context) {
 		return
 	}
 
+	if project.Suspended {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"response": "Project is suspended",
+		})
+		return
+	}
+
+	if !validCanaryWeight(deploymentPayload.CanaryWeight) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "canaryWeight must be a percentage between 0 and 100",
+		})
+		return
+	}
+
 	// Check if there is already an existing deployment running or not
 
 	// else
 
+	if len(project.GitUrl) == 0 {
+		app.errorLogger.Println("Payload GitHub URL is not valid.")
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Send a valid Github Repository URL",
+		})
+		return
+	}
+
+	// check the storage quota of the user's plan before starting the build
+	user, err := app.userDBController.GetUser(loggedInUserId)
+	if err != nil {
+		app.errorLogger.Println("unable to query the user using ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+	plan := user.CurrentPlan()
+
+	storageUsed, err := app.deploymentController.StorageUsedByUser(user.ID)
+	if err != nil {
+		app.errorLogger.Println("unable to calculate the storage used by the user", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	if storageUsed >= plan.StorageQuota {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"response": fmt.Sprintf("Storage quota exceeded, %d of %d bytes of the %s plan are used. Delete old deployments or lower the retention of your projects.", storageUsed, plan.StorageQuota, plan.Name),
+		})
+		return
+	}
+
+	// Save deployment into the Database, its ID is the prefix of the uploaded artifacts
+	deploymentPayload.ProjectID = project.ID
+	deploymentPayload.Status = models.QUEUE
+	deploymentID, err := app.deploymentController.Insert(deploymentPayload)
+	if err != nil {
+		app.errorLogger.Println("Unable to save deployment data into DB.", err)
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{
+				"response": "Internal Server Error.",
+			})
+		return
+	}
+
 	// configure the AWS SDK to run ECS Task
 	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("ap-south-1"))
 	if err != nil {
@@ -81,70 +146,66 @@ func (app *app) deploymentHandler(ctx *gin.Context) {
 	ecsClient := ecs.NewFromConfig(cfg)
 
 	// Run the ECS TASK
-	if len(project.GitUrl) != 0 {
-		projectID := strconv.FormatUint(uint64(project.ID), 10)
-
-		err = runEcsTask(ecsClient, project.GitUrl, projectID)
-		if err != nil {
-			app.errorLogger.Println("Unable to Run the ECS TASK", err)
-		}
-
-		deploymentPayload.ProjectID = project.ID
-		// Save deployment into the Database
-		deploymentID, err := app.deploymentController.Insert(deploymentPayload)
-		if err != nil {
-			app.errorLogger.Println("Unable to save deployment data into DB.", err)
-			ctx.JSON(http.StatusInternalServerError,
-				gin.H{
-					"response": "Internal Server Error.",
-				})
-		}
+	projectID := strconv.FormatUint(uint64(project.ID), 10)
+	buildEnv := map[string]string{
+		"GITHUB_REPO_URL": project.GitUrl,
+		"projectID":       projectID,
+		"deploymentID":    strconv.Itoa(deploymentID),
+		"API_URL":         app.config.apiURL,
+		"BUILD_TOKEN":     buildToken(strconv.Itoa(deploymentID)),
+		// limits of the build output, checked by the build server before the upload
+		"MAX_OUTPUT_FILES":        strconv.Itoa(plan.MaxFiles),
+		"MAX_FILE_SIZE":           strconv.FormatInt(plan.MaxFileSize, 10),
+		"MAX_OUTPUT_SIZE":         strconv.FormatInt(plan.MaxOutputSize, 10),
+		"STORAGE_QUOTA_REMAINING": strconv.FormatInt(plan.StorageQuota-storageUsed, 10),
+		"SECRET_SCAN_POLICY":      project.SecretScanPolicy,
+		"RUNTIME":                 project.Runtime,
+	}
 
-		// send the respose to user with website URL
-		websiteURL := "http://" + projectID + ".localhost:8080"
-		ctx.JSON(http.StatusOK, gin.H{
-			"status":        "Start deploying...",
-			"websiteUrl":    websiteURL,
-			"deployment ID": deploymentID,
-		})
-	} else {
-		app.errorLogger.Println("Payload GitHub URL is not valid.")
-		ctx.JSON(http.StatusNotFound, gin.H{
-			"response": "Send a valid Github Repository URL",
+	err = runEcsTask(ecsClient, buildEnv)
+	if err != nil {
+		app.errorLogger.Println("Unable to Run the ECS TASK", err)
+		app.deploymentController.UpdateStatus(deploymentID, models.FAIL, "unable to start the build", 0, 0, 0)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Unable to start the deployment.",
 		})
 		return
 	}
 
+	err = app.deploymentController.UpdateStatus(deploymentID, models.PROGRESS, "", 0, 0, 0)
+	if err != nil {
+		app.errorLogger.Println("Unable to update the deployment status.", err)
+	}
+
+	// send the respose to user with website URL
+	websiteURL := app.siteURL(project.Slug)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":        "Start deploying...",
+		"websiteUrl":    websiteURL,
+		"previewUrl":    app.previewURL(uint(deploymentID), project.Slug),
+		"deployment ID": deploymentID,
+	})
 }
 
-// Run the ECS task: Run the task from task defination
-func runEcsTask(ecsClient *ecs.Client, githubRepoUrlEnv string, projectID string) error {
+// Run the ECS task: Run the task from task defination, env overrides the environment of the build container
+func runEcsTask(ecsClient *ecs.Client, env map[string]string) error {
 
 	cluster := "arn:aws:ecs:ap-south-1:637423604544:cluster/scale-mesh-build"
 	taskDefinition := "arn:aws:ecs:ap-south-1:637423604544:task-definition/build-server-container-task:3"
 	var count int32 = 1
 
-	// projectID env variable override
-	projectIDEnvKey := "projectID"
-	projectIDEnvValue := projectID
-
-	// GITHUB_REPO_URL env variable override
-	githubRepoUrlEnvKey := "GITHUB_REPO_URL"
-	githubRepoUrlEnvValue := githubRepoUrlEnv
-
-	ketValuePairProjectID := types.KeyValuePair{
-		Name:  &projectIDEnvKey,
-		Value: &projectIDEnvValue,
-	}
-
-	keyValuePairGithubRepoUrl := types.KeyValuePair{
-		Name:  &githubRepoUrlEnvKey,
-		Value: &githubRepoUrlEnvValue,
+	// env variable overrides
+	environment := []types.KeyValuePair{}
+	for key, value := range env {
+		environment = append(environment, types.KeyValuePair{
+			Name:  aws.String(key),
+			Value: aws.String(value),
+		})
 	}
 
 	containerName := "build-container"
 	containerOverride := types.ContainerOverride{
-		Environment: []types.KeyValuePair{keyValuePairGithubRepoUrl, ketValuePairProjectID},
+		Environment: environment,
 		Name:        &containerName,
 	}
 
@@ -232,8 +293,36 @@ func (app *app) projectHandler(ctx *gin.Context) {
 		return
 	}
 
+	if projectData.Runtime != "" && !validRuntime(projectData.Runtime) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "runtime must be static or app",
+		})
+		return
+	}
+
+	if projectData.Slug == "" {
+		projectData.Slug, err = app.uniqueSlug(projectData.Name)
+		if err != nil {
+			app.errorLogger.Println("Unable to generate the project slug.", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"response": "Internal Server Error",
+			})
+			return
+		}
+	} else if !validSlug(projectData.Slug) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "Slug must be a lowercase DNS label, not numeric and not reserved.",
+		})
+		return
+	}
+
 	id, err := app.projectModel.Insert(projectData)
-	if err != nil {
+	if err == models.ErrDuplicateSlug {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"response": "Slug is already used by a project.",
+		})
+		return
+	} else if err != nil {
 		app.errorLogger.Println(err)
 		ctx.JSON(
 			http.StatusInternalServerError,
@@ -249,6 +338,8 @@ func (app *app) projectHandler(ctx *gin.Context) {
 		gin.H{
 			"response":   "Project info saved successfully.",
 			"Project ID": id,
+			"slug":       projectData.Slug,
+			"websiteUrl": app.siteURL(projectData.Slug),
 		},
 	)
 }
@@ -349,3 +440,502 @@ func (app *app) userLogoutHandler(ctx *gin.Context) {
 	})
 
 }
+
+// list the files of a deployment from its artifact manifest
+func (app *app) deploymentFilesHandler(ctx *gin.Context) {
+	deploymentID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Deployment does not exist",
+		})
+		return
+	}
+
+	deployment, err := app.deploymentController.Get(deploymentID)
+	if err == models.ErrNoRecord {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Deployment does not exist",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the deployment using ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	// the deployments of other users don't exist for this one
+	if deployment.Project.UserID != app.loggedInUserID(ctx) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Deployment does not exist",
+		})
+		return
+	}
+
+	if deployment.Status != models.READY {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": fmt.Sprintf("Deployment is not ready, current status %s", deployment.Status),
+		})
+		return
+	}
+
+	if deployment.ArtifactsDeletedAt != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Artifacts of the deployment were deleted by the retention policy",
+		})
+		return
+	}
+
+	manifest, err := app.storage.ReadManifest(ctx.Request.Context(), deployment.ID)
+	if err == storage.ErrNotFound {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Deployment has no manifest",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("unable to read the deployment manifest", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, manifest)
+}
+
+type deploymentStatusPayload struct {
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	TotalFiles int    `json:"totalFiles"`
+	TotalSize  int64  `json:"totalSize"`
+	StoredSize int64  `json:"storedSize"`
+}
+
+// build server reports the result of a build, a ready deployment becomes the live one of its project
+func (app *app) deploymentStatusHandler(ctx *gin.Context) {
+	deploymentID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Deployment does not exist",
+		})
+		return
+	}
+
+	payload := deploymentStatusPayload{}
+	err = json.NewDecoder(ctx.Request.Body).Decode(&payload)
+	if err != nil {
+		app.errorLogger.Println("Unable to decode the payload JSON.", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "Not a valid request payload.",
+		})
+		return
+	}
+
+	status, err := models.ParseStatus(payload.Status)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": fmt.Sprintf("%s", err),
+		})
+		return
+	}
+
+	deployment, err := app.deploymentController.Get(deploymentID)
+	if err == models.ErrNoRecord {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Deployment does not exist",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the deployment using ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+	// the build reports its result once
+	if deployment.Status == models.READY || deployment.Status == models.FAIL {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"response": "The deployment status is already reported.",
+		})
+		return
+	}
+
+	err = app.deploymentController.UpdateStatus(deploymentID, status, payload.Message, payload.TotalFiles, payload.TotalSize, payload.StoredSize)
+	if err != nil {
+		app.errorLogger.Println("Unable to update the deployment status.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	// a canary build only goes live once promoted, the first build of a project has nothing to split with.
+	// An app is served once it runs and is healthy
+	if status == models.READY && deployment.Project.Runtime == models.RuntimeApp {
+		go app.startApp(deployment)
+	} else if status == models.READY {
+		err = app.releaseDeployment(deployment)
+		if err != nil {
+			app.errorLogger.Println("Unable to serve the deployment.", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"response": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"response": "Deployment status updated.",
+	})
+}
+
+// reverse proxy looks up which deployment to serve for a project
+func (app *app) projectRouteHandler(ctx *gin.Context) {
+	project, ok := app.internalProject(ctx)
+	if !ok {
+		return
+	}
+
+	app.respondRoute(ctx, project)
+}
+
+// reverse proxy looks up the live deployment of a {slug}.{baseDomain} host
+func (app *app) slugRouteHandler(ctx *gin.Context) {
+	project, err := app.projectModel.GetBySlug(ctx.Param("slug"))
+	if err == models.ErrNoRecord {
+		app.notFound(ctx.Writer)
+		return
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the project using slug", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	app.respondRoute(ctx, project)
+}
+
+// respond with the route of the live deployment of the project
+func (app *app) respondRoute(ctx *gin.Context, project models.Project) {
+	if project.LiveDeploymentID == 0 {
+		app.notFound(ctx.Writer)
+		return
+	}
+
+	deploymentAccess, err := app.deploymentController.AccessModes([]uint{project.LiveDeploymentID, project.CanaryDeploymentID})
+	if err != nil {
+		app.errorLogger.Println("unable to query the access mode of the deployment", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, projectRoute(project, deploymentAccess))
+}
+
+// route of a project as the reverse proxy expects it, deploymentAccess holds the access modes
+// of the live and canary deployments which override the one of the project
+func projectRoute(project models.Project, deploymentAccess map[uint]string) gin.H {
+	route := gin.H{
+		"projectId":    project.ID,
+		"slug":         project.Slug,
+		"deploymentId": project.LiveDeploymentID,
+		"spaFallback":  project.SPAFallback,
+		"cleanUrls":    project.CleanURLs,
+	}
+	if project.Runtime == models.RuntimeApp {
+		route["runtime"] = models.RuntimeApp
+	}
+
+	if access := siteAccess(project, deploymentAccess[project.LiveDeploymentID]); access != nil {
+		route["access"] = access
+	}
+	if project.CanaryDeploymentID != 0 && project.CanaryWeight > 0 {
+		canary := gin.H{
+			"deploymentId": project.CanaryDeploymentID,
+			"weight":       project.CanaryWeight,
+		}
+		// the canary is protected as it would be once promoted
+		if access := siteAccess(project, deploymentAccess[project.CanaryDeploymentID]); access != nil {
+			canary["access"] = access
+		}
+		route["canary"] = canary
+	}
+	if project.RateLimit > 0 {
+		route["rateLimit"] = project.RateLimit
+	}
+	if project.RateLimitPerIP > 0 {
+		route["rateLimitPerIp"] = project.RateLimitPerIP
+	}
+	if headers := securityHeaders(project); len(headers) > 0 {
+		route["headers"] = headers
+	}
+	if origins := corsOrigins(project); len(origins) > 0 {
+		route["corsOrigins"] = origins
+	}
+	if project.Maintenance {
+		route["maintenance"] = maintenanceRoute(project)
+	}
+	if project.Suspended {
+		route["suspension"] = gin.H{"message": project.SuspensionMessage}
+	}
+
+	return route
+}
+
+// settings of a project which can be changed, nil fields are left unchanged
+type projectSettingsPayload struct {
+	Slug             *string `json:"slug"`
+	KeepDeployments  *int    `json:"keepDeployments"`
+	KeepDays         *int    `json:"keepDays"`
+	SecretScanPolicy *string `json:"secretScanPolicy"`
+	SPAFallback      *bool   `json:"spaFallback"`
+	CleanURLs        *bool   `json:"cleanUrls"`
+	AccessMode       *string `json:"accessMode"`
+	AccessUsername   *string `json:"accessUsername"`
+	AccessPassword   *string `json:"accessPassword"`
+	RateLimit        *int    `json:"rateLimit"`
+	RateLimitPerIP   *int    `json:"rateLimitPerIp"`
+	// security headers, an empty value keeps the platform default and off sends none
+	ContentSecurityPolicy   *string   `json:"contentSecurityPolicy"`
+	StrictTransportSecurity *string   `json:"strictTransportSecurity"`
+	ReferrerPolicy          *string   `json:"referrerPolicy"`
+	PermissionsPolicy       *string   `json:"permissionsPolicy"`
+	FrameOptions            *string   `json:"frameOptions"`
+	CORSOrigins             *[]string `json:"corsOrigins"`
+	// how the project is served, static or app, and how its app runs
+	Runtime         *string `json:"runtime"`
+	StartCommand    *string `json:"startCommand"`
+	HealthCheckPath *string `json:"healthCheckPath"`
+}
+
+// update the settings of a project
+func (app *app) projectSettingsHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	payload := projectSettingsPayload{}
+	err := json.NewDecoder(ctx.Request.Body).Decode(&payload)
+	if err != nil {
+		app.errorLogger.Println("Unable to decode the payload JSON.", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "Not a valid request payload.",
+		})
+		return
+	}
+
+	settings := map[string]interface{}{}
+	if payload.Slug != nil && *payload.Slug != project.Slug {
+		if !validSlug(*payload.Slug) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "Slug must be a lowercase DNS label, not numeric and not reserved.",
+			})
+			return
+		}
+		taken, err := app.projectModel.SlugTaken(*payload.Slug, project.ID)
+		if err != nil {
+			app.errorLogger.Println("Unable to check the slug.", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"response": "Internal Server Error",
+			})
+			return
+		}
+		if taken {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"response": "Slug is already used by a project.",
+			})
+			return
+		}
+		settings["slug"] = *payload.Slug
+	}
+	if payload.KeepDeployments != nil {
+		if *payload.KeepDeployments < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "keepDeployments can not be negative",
+			})
+			return
+		}
+		settings["keep_deployments"] = *payload.KeepDeployments
+	}
+	if payload.KeepDays != nil {
+		if *payload.KeepDays < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "keepDays can not be negative",
+			})
+			return
+		}
+		settings["keep_days"] = *payload.KeepDays
+	}
+	if payload.SecretScanPolicy != nil {
+		switch *payload.SecretScanPolicy {
+		case models.SecretScanBlock, models.SecretScanWarn, models.SecretScanOff:
+			settings["secret_scan_policy"] = *payload.SecretScanPolicy
+		default:
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "secretScanPolicy must be block, warn or off",
+			})
+			return
+		}
+	}
+
+	if payload.Runtime != nil {
+		if !validRuntime(*payload.Runtime) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "runtime must be static or app",
+			})
+			return
+		}
+		// the live deployment was built for the runtime it has
+		if *payload.Runtime != project.Runtime && project.LiveDeploymentID != 0 {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"response": "runtime can not change once the project has a live deployment",
+			})
+			return
+		}
+		settings["runtime"] = *payload.Runtime
+	}
+	if payload.StartCommand != nil {
+		settings["start_command"] = strings.TrimSpace(*payload.StartCommand)
+	}
+	if payload.HealthCheckPath != nil {
+		if *payload.HealthCheckPath != "" && !strings.HasPrefix(*payload.HealthCheckPath, "/") {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "healthCheckPath must start with a slash",
+			})
+			return
+		}
+		settings["health_check_path"] = *payload.HealthCheckPath
+	}
+
+	if payload.SPAFallback != nil {
+		settings["spa_fallback"] = *payload.SPAFallback
+	}
+	if payload.CleanURLs != nil {
+		settings["clean_urls"] = *payload.CleanURLs
+	}
+
+	if payload.RateLimit != nil {
+		if *payload.RateLimit < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "rateLimit can not be negative",
+			})
+			return
+		}
+		settings["rate_limit"] = *payload.RateLimit
+	}
+	if payload.RateLimitPerIP != nil {
+		if *payload.RateLimitPerIP < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "rateLimitPerIp can not be negative",
+			})
+			return
+		}
+		settings["rate_limit_per_ip"] = *payload.RateLimitPerIP
+	}
+
+	err = app.pathRoutingSettings(payload)
+	if err == nil {
+		err = accessSettings(project, payload, settings)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": err.Error(),
+		})
+		return
+	}
+
+	err = headerSettings(payload, settings)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": err.Error(),
+		})
+		return
+	}
+
+	if len(settings) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "No settings to update.",
+		})
+		return
+	}
+
+	err = app.projectModel.UpdateSettings(project.ID, settings)
+	if err == models.ErrDuplicateSlug {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"response": "Slug is already used by a project.",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("Unable to update the project settings.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	// the proxies drop the old slug, the other settings change the route itself
+	if _, ok := settings["slug"]; ok {
+		app.publishRouteChange(project.ID, "settings", project.Slug)
+	} else {
+		app.publishRouteChange(project.ID, "settings")
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"response": "Project settings updated.",
+	})
+}
+
+// run the artifact garbage collector for a project, with ?dryRun=true it only lists what would be deleted
+func (app *app) projectGarbageCollectionHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	dryRun := ctx.Query("dryRun") == "true"
+	collected, err := app.collectProjectGarbage(ctx.Request.Context(), project, dryRun)
+	if err != nil {
+		app.errorLogger.Printf("GC: unable to collect the artifacts of project %d, %s", project.ID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response":    "Unable to delete every expired artifact.",
+			"deployments": collected,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"dryRun":      dryRun,
+		"deployments": collected,
+	})
+}
+
+// storage used by the logged in user and the limits of the plan
+func (app *app) userUsageHandler(ctx *gin.Context) {
+	user, err := app.userDBController.GetUser(int(app.loggedInUserID(ctx)))
+	if err != nil {
+		app.errorLogger.Println("unable to query the user using ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	storageUsed, err := app.deploymentController.StorageUsedByUser(user.ID)
+	if err != nil {
+		app.errorLogger.Println("unable to calculate the storage used by the user", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"plan":        user.CurrentPlan(),
+		"storageUsed": storageUsed,
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+)
+
+// value of a security header setting which drops the platform default
+const headerOff = "off"
+
+var referrerPolicies = map[string]bool{
+	"no-referrer": true, "no-referrer-when-downgrade": true, "origin": true,
+	"origin-when-cross-origin": true, "same-origin": true, "strict-origin": true,
+	"strict-origin-when-cross-origin": true, "unsafe-url": true,
+}
+
+// security headers of the project in the route, only the ones overriding the defaults of the proxy
+func securityHeaders(project models.Project) gin.H {
+	headers := gin.H{}
+	for name, value := range map[string]string{
+		"contentSecurityPolicy":   project.ContentSecurityPolicy,
+		"strictTransportSecurity": project.StrictTransportSecurity,
+		"referrerPolicy":          project.ReferrerPolicy,
+		"permissionsPolicy":       project.PermissionsPolicy,
+		"frameOptions":            project.FrameOptions,
+	} {
+		if value != "" {
+			headers[name] = value
+		}
+	}
+
+	return headers
+}
+
+func corsOrigins(project models.Project) []string {
+	if project.CORSOrigins == "" {
+		return nil
+	}
+	return strings.Split(project.CORSOrigins, ",")
+}
+
+// headerSettings validates the security headers and the CORS origins of the payload into the settings
+func headerSettings(payload projectSettingsPayload, settings map[string]interface{}) error {
+	headers := []struct {
+		name    string
+		column  string
+		value   *string
+		isValid func(string) bool
+	}{
+		{"contentSecurityPolicy", "content_security_policy", payload.ContentSecurityPolicy, nil},
+		{"strictTransportSecurity", "strict_transport_security", payload.StrictTransportSecurity, validHSTS},
+		{"referrerPolicy", "referrer_policy", payload.ReferrerPolicy, validReferrerPolicy},
+		{"permissionsPolicy", "permissions_policy", payload.PermissionsPolicy, nil},
+		{"frameOptions", "frame_options", payload.FrameOptions, validFrameOptions},
+	}
+	for _, header := range headers {
+		if header.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*header.value)
+		// the value is sent as it is in a response header
+		if strings.ContainsAny(value, "\r\n") || len(value) > 4096 {
+			return errors.New(header.name + " is not a valid header value")
+		}
+		if value != "" && value != headerOff && header.isValid != nil && !header.isValid(value) {
+			return errors.New(header.name + " is not a valid header value")
+		}
+		settings[header.column] = value
+	}
+
+	if payload.CORSOrigins != nil {
+		origins := []string{}
+		for _, origin := range *payload.CORSOrigins {
+			origin = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+			if !validOrigin(origin) {
+				return errors.New("corsOrigins must be * or origins like https://example.com")
+			}
+			origins = append(origins, origin)
+		}
+		settings["cors_origins"] = strings.Join(origins, ",")
+	}
+
+	return nil
+}
+
+// max-age=N with the optional includeSubDomains and preload directives
+func validHSTS(value string) bool {
+	directives := strings.Split(value, ";")
+	maxAge, found := strings.CutPrefix(strings.TrimSpace(directives[0]), "max-age=")
+	if !found || maxAge == "" || strings.Trim(maxAge, "0123456789") != "" {
+		return false
+	}
+	for _, directive := range directives[1:] {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "includesubdomains", "preload":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// one policy, or a comma separated list of fallbacks
+func validReferrerPolicy(value string) bool {
+	for _, policy := range strings.Split(value, ",") {
+		if !referrerPolicies[strings.ToLower(strings.TrimSpace(policy))] {
+			return false
+		}
+	}
+	return true
+}
+
+func validFrameOptions(value string) bool {
+	return strings.EqualFold(value, "DENY") || strings.EqualFold(value, "SAMEORIGIN")
+}
+
+// * or a scheme and a host, with an optional port and nothing else
+func validOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return false
+	}
+	return parsed.User == nil && parsed.Path == "" && parsed.RawQuery == "" && parsed.Fragment == "" && !strings.Contains(origin, ",")
+}
@@ -5,8 +5,10 @@ import (
 	"log"
 	"net/http"
 	"runtime/debug"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
 )
 
 // Centralized Error Helpers
@@ -25,3 +27,54 @@ func (app *app) clientError(g gin.ResponseWriter, status int) {
 func (app *app) notFound(g gin.ResponseWriter) {
 	app.clientError(g, http.StatusNotFound)
 }
+
+// ID of the user logged in with the session cookie, 0 if there is none
+func (app *app) loggedInUserID(ctx *gin.Context) uint {
+	session, err := app.session.Get(ctx.Request, "thisSession")
+	if err != nil {
+		app.errorLogger.Println("unable to get the session", err)
+		return 0
+	}
+
+	id, ok := session.Values["id"].(int)
+	if !ok {
+		return 0
+	}
+
+	return uint(id)
+}
+
+// fetch the project of the :id param, responds with an error and returns false
+// if it doesn't exist or doesn't belong to the logged in user
+func (app *app) ownedProject(ctx *gin.Context) (models.Project, bool) {
+	projectID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Project Id does not exist",
+		})
+		return models.Project{}, false
+	}
+
+	project, err := app.projectModel.CheckExistingProject(projectID)
+	if err == models.ErrNoRecord {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Project Id does not exist",
+		})
+		return project, false
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the project using ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return project, false
+	}
+
+	if project.UserID != app.loggedInUserID(ctx) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"response": "User not authorized",
+		})
+		return project, false
+	}
+
+	return project, true
+}
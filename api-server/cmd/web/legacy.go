@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/storage"
+)
+
+// isLegacySite tells whether __output/{projectID}/ holds the site the project had before every deployment
+// had its own prefix, and not the artifacts of the deployment with the same ID: those have a manifest,
+// and the build of a deployment only uploads once it left the queue
+func (app *app) isLegacySite(ctx context.Context, projectID uint) (bool, error) {
+	found, err := app.storage.HasObjects(ctx, storage.LegacyArtifactPrefix(projectID))
+	if err != nil || !found {
+		return false, err
+	}
+
+	hasManifest, err := app.storage.Exists(ctx, storage.ManifestKey(projectID))
+	if err != nil || hasManifest {
+		return false, err
+	}
+
+	deployment, err := app.deploymentController.Get(int(projectID))
+	if err == models.ErrNoRecord {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return deployment.Status == models.QUEUE, nil
+}
+
+// move the sites deployed before every deployment had its own artifact prefix to a ready deployment,
+// which goes live so the site is served again
+func (app *app) backfillLegacySites(ctx context.Context) error {
+	projects, err := app.projectModel.ListNotLive()
+	if err != nil {
+		return err
+	}
+
+	for _, project := range projects {
+		legacy, err := app.isLegacySite(ctx, project.ID)
+		if err != nil {
+			return err
+		}
+		if !legacy {
+			continue
+		}
+
+		deploymentID, err := app.deploymentController.InsertMigrated(project.ID)
+		if err != nil {
+			return err
+		}
+
+		manifest, err := app.storage.CopyLegacyArtifacts(ctx, project.ID, deploymentID)
+		if err != nil {
+			// the legacy artifacts are kept, the next start tries again
+			app.deploymentController.Fail(deploymentID, "unable to migrate the legacy artifacts")
+			return err
+		}
+
+		err = app.deploymentController.UpdateStatus(int(deploymentID), models.READY, "", manifest.TotalFiles, manifest.TotalSize, manifest.StoredSize)
+		if err != nil {
+			return err
+		}
+		err = app.projectModel.SetLiveDeployment(project.ID, deploymentID)
+		if err != nil {
+			return err
+		}
+
+		_, err = app.storage.DeletePrefix(ctx, storage.LegacyArtifactPrefix(project.ID))
+		if err != nil {
+			return err
+		}
+		app.infoLogger.Printf("the %d legacy artifacts of project %d are live as deployment %d", manifest.TotalFiles, project.ID, deploymentID)
+	}
+
+	return nil
+}
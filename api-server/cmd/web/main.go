@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
 	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
 	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models/postgresql"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/storage"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -17,8 +22,33 @@ var dsn = os.Getenv("DBURI")
 
 var store = sessions.NewCookieStore([]byte(os.Getenv("SESSION_KEY")))
 
+// shared secret of the reverse proxy and the app runner to call the internal endpoints, builds get a token of their deployment
+var internalToken = os.Getenv("INTERNAL_TOKEN")
+
+// accessSigningKey signs the access tokens of team members, it is never given to builds or apps
+var accessSigningKey = os.Getenv("ACCESS_SIGNING_KEY")
+
 type ApiConfig struct {
-	address string
+	address         string
+	apiURL          string
+	s3Bucket        string
+	sitesDomain     string
+	sitesScheme     string
+	sitesRouting    string
+	appRunnerURL    string
+	redisAddress    string
+	routesChannel   string
+	keepDeployments int
+	keepDays        int
+	gcInterval      time.Duration
+	gcDryRun        bool
+	// custom domain verification
+	dnsResolver           string
+	domainCheckInterval   time.Duration
+	domainRecheckInterval time.Duration
+	domainVerifyTimeout   time.Duration
+	domainFailures        int
+	analyticsRetention    time.Duration
 }
 
 type app struct {
@@ -27,7 +57,13 @@ type app struct {
 	projectModel         *postgresql.ProjectModel
 	userDBController     *postgresql.UserDBController
 	deploymentController *postgresql.DeploymentController
+	domainController     *postgresql.DomainController
+	trafficController    *postgresql.TrafficController
 	session              *sessions.CookieStore
+	storage              *storage.S3Store
+	resolver             *net.Resolver
+	redis                *redis.Client // nil when route change events are disabled
+	config               ApiConfig
 }
 
 func main() {
@@ -64,22 +100,96 @@ func main() {
 		DatabaseConnectionPool: dbConnectionPool,
 	}
 
+	domainController := postgresql.DomainController{
+		DatabaseConnectionPool: dbConnectionPool,
+	}
+
+	trafficController := postgresql.TrafficController{
+		DatabaseConnectionPool: dbConnectionPool,
+	}
+
 	apiConfig := ApiConfig{}
 	// Create Levelled Logging
 	infoLogger := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLogger := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
+	flag.StringVar(&apiConfig.address, "address", ":9000", "Port of the api")
+	flag.StringVar(&apiConfig.apiURL, "api-url", "http://127.0.0.1:9000", "URL of the api reachable from the build server")
+	flag.StringVar(&apiConfig.s3Bucket, "s3-bucket", "scale-mesh-s3", "S3 bucket of the build artifacts")
+	flag.StringVar(&apiConfig.sitesDomain, "sites-domain", "localhost:8080", "Domain of the sites served by the reverse proxy, sites are addressed as {slug}.{sitesDomain}")
+	flag.StringVar(&apiConfig.sitesScheme, "sites-scheme", "http", "Scheme of the site URLs, https when the reverse proxy terminates TLS")
+	flag.StringVar(&apiConfig.sitesRouting, "sites-routing", "host", "How the site URLs address the sites: host for {slug}.{sitesDomain}, path for {sitesDomain}/{slug}/ when the reverse proxy runs with -path-routing")
+	flag.StringVar(&apiConfig.appRunnerURL, "app-runner-url", "http://127.0.0.1:9300", "URL of the app runner which runs the deployments of app projects")
+	flag.StringVar(&apiConfig.redisAddress, "redis-address", "", "Redis to publish the route changes to the reverse proxies, disabled if empty")
+	flag.StringVar(&apiConfig.routesChannel, "routes-channel", "routes", "Redis channel of the route changes")
+	flag.IntVar(&apiConfig.keepDeployments, "retain-deployments", 10, "Default number of ready deployments whose artifacts are kept")
+	flag.IntVar(&apiConfig.keepDays, "retain-days", 30, "Default number of days the artifacts of a deployment are kept")
+	flag.DurationVar(&apiConfig.gcInterval, "gc-interval", time.Hour, "Interval of the artifact garbage collector, 0 disables it")
+	flag.BoolVar(&apiConfig.gcDryRun, "gc-dry-run", false, "Only log the artifacts the garbage collector would delete")
+	flag.StringVar(&apiConfig.dnsResolver, "dns-resolver", "", "DNS server to verify custom domains, host:port, the system resolver if empty")
+	flag.DurationVar(&apiConfig.domainCheckInterval, "domain-check-interval", 5*time.Minute, "Interval of the custom domain verification checks")
+	flag.DurationVar(&apiConfig.domainRecheckInterval, "domain-recheck-interval", 24*time.Hour, "How often a verified domain is checked again")
+	flag.DurationVar(&apiConfig.domainVerifyTimeout, "domain-verify-timeout", 72*time.Hour, "How long a domain stays pending before it fails verification")
+	flag.IntVar(&apiConfig.domainFailures, "domain-failures", 3, "Checks in a row without the TXT record before a verified domain fails")
+	flag.DurationVar(&apiConfig.analyticsRetention, "analytics-retention", 90*24*time.Hour, "How long the traffic counters of the projects are kept, forever if 0")
+	flag.Parse()
+
+	if apiConfig.sitesRouting != "host" && apiConfig.sitesRouting != "path" {
+		log.Fatal("ERROR: -sites-routing must be host or path")
+	}
+
+	// artifact storage
+	artifactStore, err := storage.NewS3Store(context.TODO(), "ap-south-1", apiConfig.s3Bucket)
+	if err != nil {
+		log.Fatal("ERROR: unable to auth with AWS", err)
+	}
+
 	app := app{
 		errorLogger:          errorLogger,
 		infoLogger:           infoLogger,
 		projectModel:         &projectModel,
 		userDBController:     &userControler,
 		deploymentController: &deploymentController,
+		domainController:     &domainController,
+		trafficController:    &trafficController,
 		session:              store,
+		storage:              artifactStore,
+		resolver:             newResolver(apiConfig.dnsResolver),
+		config:               apiConfig,
 	}
 
-	flag.StringVar(&apiConfig.address, "address", ":9000", "Port of the api")
-	flag.Parse()
+	if apiConfig.redisAddress != "" {
+		app.redis = redis.NewClient(&redis.Options{
+			Addr:     apiConfig.redisAddress,
+			Password: os.Getenv("REDIS_PASSWORD"),
+			DB:       0,
+		})
+		_, err = app.redis.Ping(context.Background()).Result()
+		if err != nil {
+			log.Println("ERROR: Unable to connect with Redis.", err)
+		}
+	}
+
+	err = app.backfillSlugs()
+	if err != nil {
+		log.Fatal("ERROR: unable to give the projects a slug", err)
+	}
+
+	// the sites left behind are migrated on the next start
+	err = app.backfillLegacySites(context.Background())
+	if err != nil {
+		app.errorLogger.Println("unable to migrate the sites deployed before the deployment manifests", err)
+	}
+
+	if apiConfig.gcInterval > 0 {
+		go app.runGarbageCollector(context.Background(), apiConfig.gcInterval, apiConfig.gcDryRun)
+	}
+
+	go app.runDomainVerifier(context.Background(), apiConfig.domainCheckInterval)
+
+	if apiConfig.analyticsRetention > 0 {
+		go app.pruneAnalytics(context.Background(), apiConfig.analyticsRetention)
+	}
 
 	server := &http.Server{
 		Addr:     apiConfig.address,
@@ -101,14 +211,17 @@ func openDBConnectionPool(dsn string) (*gorm.DB, error) {
 		GO manages these connection as needed, opening and closing connections to the database as needed.
 		so, actual connection to the database is done lazily, as when needed for the first time.
 	*/
-	dbConnectionPool, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	dbConnectionPool, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		// unique violations are returned as gorm.ErrDuplicatedKey
+		TranslateError: true,
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
 	// Run the automigration for Project Model
-	if err := dbConnectionPool.AutoMigrate(&models.Project{}, &models.User{}, &models.Deployment{}); err != nil {
+	if err := dbConnectionPool.AutoMigrate(&models.Project{}, &models.User{}, &models.Deployment{}, &models.Domain{}, &models.TrafficStat{}, &models.TrafficPath{}); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+)
+
+type maintenancePayload struct {
+	Message    string   `json:"message"`
+	RetryAfter int      `json:"retryAfter"`
+	AllowedIPs []string `json:"allowedIps"`
+}
+
+type suspensionPayload struct {
+	Message string `json:"message"`
+}
+
+// messages are shown on the 503 page of the site
+const maxStateMessage = 1000
+
+// maintenanceRoute is the maintenance of the project as the reverse proxy expects it
+func maintenanceRoute(project models.Project) gin.H {
+	allowedIPs := []string{}
+	if project.MaintenanceAllowedIPs != "" {
+		allowedIPs = strings.Split(project.MaintenanceAllowedIPs, ",")
+	}
+
+	return gin.H{
+		"message":    project.MaintenanceMessage,
+		"retryAfter": project.MaintenanceRetryAfter,
+		"allowedIps": allowedIPs,
+	}
+}
+
+// stateResponse tells whether the site is served, in maintenance or suspended
+func stateResponse(project models.Project) gin.H {
+	response := gin.H{
+		"maintenance": nil,
+		"suspended":   project.Suspended,
+	}
+	if project.Maintenance {
+		response["maintenance"] = maintenanceRoute(project)
+	}
+	if project.Suspended {
+		response["suspensionMessage"] = project.SuspensionMessage
+	}
+
+	return response
+}
+
+// an address or a CIDR, in its canonical form
+func parseAllowedIP(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return "", false
+		}
+		return prefix.Masked().String(), true
+	}
+	address, err := netip.ParseAddr(value)
+	if err != nil {
+		return "", false
+	}
+	return address.String(), true
+}
+
+// whether the site of the project is in maintenance and suspended
+func (app *app) maintenanceHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, stateResponse(project))
+}
+
+// take the site offline, the visitors get a 503 page with the message except from the allowed IPs
+func (app *app) startMaintenanceHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	payload := maintenancePayload{}
+	if ctx.Request.ContentLength != 0 {
+		err := json.NewDecoder(ctx.Request.Body).Decode(&payload)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "Not a valid request payload.",
+			})
+			return
+		}
+	}
+
+	payload.Message = strings.TrimSpace(payload.Message)
+	if len(payload.Message) > maxStateMessage {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "message can be at most " + strconv.Itoa(maxStateMessage) + " characters",
+		})
+		return
+	}
+	if payload.RetryAfter < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "retryAfter can not be negative",
+		})
+		return
+	}
+	allowedIPs := []string{}
+	for _, value := range payload.AllowedIPs {
+		allowed, ok := parseAllowedIP(value)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "allowedIps must be IP addresses or CIDRs, " + value + " is not",
+			})
+			return
+		}
+		allowedIPs = append(allowedIPs, allowed)
+	}
+
+	err := app.projectModel.UpdateSettings(project.ID, map[string]interface{}{
+		"maintenance":             true,
+		"maintenance_message":     payload.Message,
+		"maintenance_retry_after": payload.RetryAfter,
+		"maintenance_allowed_ips": strings.Join(allowedIPs, ","),
+	})
+	if err != nil {
+		app.errorLogger.Println("Unable to start the maintenance.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+	app.publishRouteChange(project.ID, "maintenance")
+
+	project.Maintenance = true
+	project.MaintenanceMessage = payload.Message
+	project.MaintenanceRetryAfter = payload.RetryAfter
+	project.MaintenanceAllowedIPs = strings.Join(allowedIPs, ",")
+	ctx.JSON(http.StatusOK, stateResponse(project))
+}
+
+// put the site back online
+func (app *app) endMaintenanceHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	if !project.Maintenance {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Project is not in maintenance",
+		})
+		return
+	}
+
+	err := app.projectModel.UpdateSettings(project.ID, map[string]interface{}{
+		"maintenance":             false,
+		"maintenance_message":     "",
+		"maintenance_retry_after": 0,
+		"maintenance_allowed_ips": "",
+	})
+	if err != nil {
+		app.errorLogger.Println("Unable to end the maintenance.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+	app.publishRouteChange(project.ID, "maintenance")
+
+	project.Maintenance = false
+	ctx.JSON(http.StatusOK, stateResponse(project))
+}
+
+// project of the :id of an internal endpoint, it responds itself when there is none
+func (app *app) internalProject(ctx *gin.Context) (models.Project, bool) {
+	projectID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		app.notFound(ctx.Writer)
+		return models.Project{}, false
+	}
+
+	project, err := app.projectModel.CheckExistingProject(projectID)
+	if err == models.ErrNoRecord {
+		app.notFound(ctx.Writer)
+		return models.Project{}, false
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the project using ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return models.Project{}, false
+	}
+
+	return project, true
+}
+
+// the platform suspends an abusive project: its site is served a 503 page and it can't deploy,
+// nothing is deleted
+func (app *app) suspendProjectHandler(ctx *gin.Context) {
+	project, ok := app.internalProject(ctx)
+	if !ok {
+		return
+	}
+
+	payload := suspensionPayload{}
+	if ctx.Request.ContentLength != 0 {
+		err := json.NewDecoder(ctx.Request.Body).Decode(&payload)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"response": "Not a valid request payload.",
+			})
+			return
+		}
+	}
+	payload.Message = strings.TrimSpace(payload.Message)
+	if len(payload.Message) > maxStateMessage {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"response": "message can be at most " + strconv.Itoa(maxStateMessage) + " characters",
+		})
+		return
+	}
+
+	err := app.projectModel.UpdateSettings(project.ID, map[string]interface{}{
+		"suspended":          true,
+		"suspension_message": payload.Message,
+	})
+	if err != nil {
+		app.errorLogger.Println("Unable to suspend the project.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+	app.infoLogger.Printf("project %d is suspended", project.ID)
+	app.publishRouteChange(project.ID, "suspension")
+
+	project.Suspended = true
+	project.SuspensionMessage = payload.Message
+	ctx.JSON(http.StatusOK, stateResponse(project))
+}
+
+// lift the suspension of the project
+func (app *app) unsuspendProjectHandler(ctx *gin.Context) {
+	project, ok := app.internalProject(ctx)
+	if !ok {
+		return
+	}
+
+	err := app.projectModel.UpdateSettings(project.ID, map[string]interface{}{
+		"suspended":          false,
+		"suspension_message": "",
+	})
+	if err != nil {
+		app.errorLogger.Println("Unable to lift the suspension of the project.", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+	app.infoLogger.Printf("suspension of project %d is lifted", project.ID)
+	app.publishRouteChange(project.ID, "suspension")
+
+	project.Suspended = false
+	project.SuspensionMessage = ""
+	ctx.JSON(http.StatusOK, stateResponse(project))
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 
@@ -20,7 +24,7 @@ func secureHeaderMiddleware(next http.Handler) http.Handler {
 
 func (app *app) logRequestMiddleware(next http.Handler) http.Handler {
 	handler := func(w http.ResponseWriter, request *http.Request) {
-		app.infoLogger.Printf("%s - %s %s %s", request.RemoteAddr, request.Proto, request.Method)
+		app.infoLogger.Printf("%s - %s %s %s", request.RemoteAddr, request.Proto, request.Method, request.URL.RequestURI())
 
 		next.ServeHTTP(w, request)
 	}
@@ -66,3 +70,45 @@ func (app *app) requireAuthenticatedUserMiddleware(next gin.HandlerFunc) gin.Han
 
 	return handler
 }
+
+// internal endpoints are called by the reverse proxy, the app runner and the platform with the shared INTERNAL_TOKEN
+func (app *app) requireInternalTokenMiddleware(next gin.HandlerFunc) gin.HandlerFunc {
+	handler := func(ctx *gin.Context) {
+		token := ctx.GetHeader("X-Internal-Token")
+		if internalToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(internalToken)) != 1 {
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"response": "Not authorized to call internal endpoints.",
+			})
+			return
+		}
+
+		next(ctx)
+	}
+
+	return handler
+}
+
+// buildToken is given to the build of a deployment instead of the INTERNAL_TOKEN, it only reports
+// the status of that deployment
+func buildToken(deploymentID string) string {
+	mac := hmac.New(sha256.New, []byte(internalToken))
+	mac.Write([]byte("build " + deploymentID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// the build server reports the status of its deployment with the X-Build-Token of the deployment
+func (app *app) requireBuildTokenMiddleware(next gin.HandlerFunc) gin.HandlerFunc {
+	handler := func(ctx *gin.Context) {
+		token := ctx.GetHeader("X-Build-Token")
+		if internalToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(buildToken(ctx.Param("id")))) != 1 {
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"response": "Not authorized to report the status of the deployment.",
+			})
+			return
+		}
+
+		next(ctx)
+	}
+
+	return handler
+}
@@ -24,10 +24,42 @@ func (app *app) routes() http.Handler {
 	router.GET("/health", app.healthHandler)
 	router.POST("/deploy", app.requireAuthenticatedUserMiddleware(app.deploymentHandler))
 	router.POST("/project", app.requireAuthenticatedUserMiddleware(app.projectHandler))
+	router.PATCH("/projects/:id", app.requireAuthenticatedUserMiddleware(app.projectSettingsHandler))
+	router.POST("/projects/:id/gc", app.requireAuthenticatedUserMiddleware(app.projectGarbageCollectionHandler))
+	router.POST("/projects/:id/domains", app.requireAuthenticatedUserMiddleware(app.attachDomainHandler))
+	router.GET("/projects/:id/domains", app.requireAuthenticatedUserMiddleware(app.listDomainsHandler))
+	router.DELETE("/projects/:id/domains/:hostname", app.requireAuthenticatedUserMiddleware(app.detachDomainHandler))
+	router.POST("/projects/:id/domains/:hostname/verify", app.requireAuthenticatedUserMiddleware(app.verifyDomainHandler))
+	router.GET("/projects/:id/canary", app.requireAuthenticatedUserMiddleware(app.canaryHandler))
+	router.PUT("/projects/:id/canary", app.requireAuthenticatedUserMiddleware(app.startCanaryHandler))
+	router.PATCH("/projects/:id/canary", app.requireAuthenticatedUserMiddleware(app.canaryWeightHandler))
+	router.DELETE("/projects/:id/canary", app.requireAuthenticatedUserMiddleware(app.abortCanaryHandler))
+	router.POST("/projects/:id/canary/promote", app.requireAuthenticatedUserMiddleware(app.promoteCanaryHandler))
+	router.GET("/projects/:id/maintenance", app.requireAuthenticatedUserMiddleware(app.maintenanceHandler))
+	router.PUT("/projects/:id/maintenance", app.requireAuthenticatedUserMiddleware(app.startMaintenanceHandler))
+	router.DELETE("/projects/:id/maintenance", app.requireAuthenticatedUserMiddleware(app.endMaintenanceHandler))
+	router.GET("/projects/:id/analytics", app.requireAuthenticatedUserMiddleware(app.projectAnalyticsHandler))
+	router.GET("/projects/:id/deployments", app.requireAuthenticatedUserMiddleware(app.listDeploymentsHandler))
+	router.GET("/deployments/:id", app.requireAuthenticatedUserMiddleware(app.getDeploymentHandler))
+	router.GET("/deployments/:id/files", app.requireAuthenticatedUserMiddleware(app.deploymentFilesHandler))
+	router.PATCH("/deployments/:id/access", app.requireAuthenticatedUserMiddleware(app.deploymentAccessHandler))
+	router.GET("/access/authorize", app.requireAuthenticatedUserMiddleware(app.authorizeAccessHandler))
 
 	router.POST("/user/signup", app.userSignupHandler)
 	router.POST("/user/login", app.userLoginHandler)
 	router.POST("/user/logout", app.userLogoutHandler)
+	router.GET("/user/usage", app.requireAuthenticatedUserMiddleware(app.userUsageHandler))
+
+	// internal endpoints for the build server and the reverse proxy
+	router.POST("/internal/deployments/:id/status", app.requireBuildTokenMiddleware(app.deploymentStatusHandler))
+	router.POST("/internal/analytics", app.requireInternalTokenMiddleware(app.recordTrafficHandler))
+	router.PUT("/internal/projects/:id/suspension", app.requireInternalTokenMiddleware(app.suspendProjectHandler))
+	router.DELETE("/internal/projects/:id/suspension", app.requireInternalTokenMiddleware(app.unsuspendProjectHandler))
+	router.GET("/internal/routes", app.requireInternalTokenMiddleware(app.routesHandler))
+	router.GET("/internal/projects/:id/route", app.requireInternalTokenMiddleware(app.projectRouteHandler))
+	router.GET("/internal/slugs/:slug/route", app.requireInternalTokenMiddleware(app.slugRouteHandler))
+	router.GET("/internal/previews/:slug/:deployment/route", app.requireInternalTokenMiddleware(app.previewRouteHandler))
+	router.GET("/internal/hosts/:host/route", app.requireInternalTokenMiddleware(app.hostRouteHandler))
 
 	return app.recoverPanic((secureHeaderMiddleware(router)))
 }
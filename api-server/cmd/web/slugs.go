@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+)
+
+// subdomains kept for the platform itself
+var reservedSlugs = map[string]bool{
+	"www": true, "api": true, "admin": true, "app": true, "dashboard": true,
+	"mail": true, "static": true, "assets": true, "cdn": true, "status": true,
+	"docs": true, "help": true, "support": true, "blog": true, "internal": true,
+}
+
+var notSlugCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify turns a project name into a DNS label, "My Site!" becomes "my-site"
+func slugify(name string) string {
+	slug := notSlugCharacters.ReplaceAllString(strings.ToLower(name), "-")
+	slug = strings.Trim(slug, "-")
+	// leave room for the suffix which makes a taken slug unique
+	if len(slug) > 50 {
+		slug = strings.TrimRight(slug[:50], "-")
+	}
+	if slug == "" {
+		slug = "site"
+	}
+
+	return slug
+}
+
+// validSlug checks the slug is a DNS label the proxy routes to a project:
+// not reserved, not numeric as numeric hosts are the old project ID addresses,
+// and without "--" which DNS keeps for punycode labels like "xn--"
+func validSlug(slug string) bool {
+	if !hostnameLabel.MatchString(slug) || reservedSlugs[slug] || strings.Contains(slug, "--") {
+		return false
+	}
+
+	return strings.Trim(slug, "0123456789") != ""
+}
+
+// uniqueSlug derives a free slug from the project name, a random suffix is added
+// when the plain slug is taken or not valid
+func (app *app) uniqueSlug(name string) (string, error) {
+	base := slugify(name)
+	slug := base
+	for {
+		if validSlug(slug) {
+			taken, err := app.projectModel.SlugTaken(slug, 0)
+			if err != nil {
+				return "", err
+			}
+			if !taken {
+				return slug, nil
+			}
+		}
+
+		suffix := make([]byte, 3)
+		_, err := rand.Read(suffix)
+		if err != nil {
+			return "", err
+		}
+		slug = base + "-" + hex.EncodeToString(suffix)
+	}
+}
+
+// give the projects created before slugs existed a slug from their name
+func (app *app) backfillSlugs() error {
+	projects, err := app.projectModel.ListWithoutSlug()
+	if err != nil {
+		return err
+	}
+
+	for _, project := range projects {
+		slug, err := app.uniqueSlug(project.Name)
+		if err != nil {
+			return err
+		}
+
+		err = app.projectModel.UpdateSettings(project.ID, map[string]interface{}{"slug": slug})
+		if err != nil {
+			return err
+		}
+		app.infoLogger.Printf("project %d is addressed as %s", project.ID, slug)
+	}
+
+	return nil
+}
+
+// with the path-based routing every site shares the origin of the sites domain, the scripts of any
+// site can read the others and a header like Strict-Transport-Security applies to all of them.
+// pathRoutingSettings refuses the access protection and the security header overrides there
+func (app *app) pathRoutingSettings(payload projectSettingsPayload) error {
+	if app.config.sitesRouting != "path" {
+		return nil
+	}
+
+	if payload.AccessMode != nil && *payload.AccessMode != "" && *payload.AccessMode != models.AccessPublic {
+		return errors.New("access protection needs the sites on their own hosts, it is not available with the path-based routing")
+	}
+	for _, value := range []*string{payload.ContentSecurityPolicy, payload.StrictTransportSecurity, payload.ReferrerPolicy, payload.PermissionsPolicy, payload.FrameOptions} {
+		if value != nil && strings.TrimSpace(*value) != "" {
+			return errors.New("security header overrides need the sites on their own hosts, they are not available with the path-based routing")
+		}
+	}
+
+	return nil
+}
+
+// URL of a site under the base domain, as a subdomain or as the first segment of the path
+func (app *app) siteURL(label string) string {
+	if app.config.sitesRouting == "path" {
+		return app.config.sitesScheme + "://" + app.config.sitesDomain + "/" + label + "/"
+	}
+	return app.config.sitesScheme + "://" + label + "." + app.config.sitesDomain
+}
+
+// URL of a deployment under the base domain, {deploymentID}--{slug}, which slugs can't collide with
+func (app *app) previewURL(deploymentID uint, slug string) string {
+	return app.siteURL(strconv.FormatUint(uint64(deploymentID), 10) + "--" + slug)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+)
+
+// challengeRecord is the name of the TXT record which proves the ownership of a domain
+func challengeRecord(hostname string) string {
+	return "_scale-mesh-challenge." + hostname
+}
+
+func newVerificationToken() (string, error) {
+	token := make([]byte, 16)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// newResolver uses the DNS server at address, e.g. "127.0.0.1:5353", or the system resolver if it is empty
+func newResolver(address string) *net.Resolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: 5 * time.Second}
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+}
+
+var errChallengeNotFound = errors.New("TXT record with the verification token not found")
+
+// lookup the challenge record of the domain, errChallengeNotFound means the DNS answered without the token,
+// any other error is a failure of the lookup itself
+func (app *app) lookupChallenge(ctx context.Context, domain models.Domain) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	records, err := app.resolver.LookupTXT(ctx, challengeRecord(domain.Hostname))
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return errChallengeNotFound
+	} else if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if strings.TrimSpace(record) == domain.VerificationToken {
+			return nil
+		}
+	}
+
+	return errChallengeNotFound
+}
+
+// verificationOutcome moves the domain through its states after a lookup of its challenge record:
+//   - pending becomes verified once the record is found, or failed after the verification timeout
+//   - verified becomes failed once the record is missing from maxFailures checks in a row
+//
+// lookup errors leave the state unchanged so a DNS outage doesn't fail domains
+func verificationOutcome(domain models.Domain, lookupErr error, now time.Time, verifyTimeout time.Duration, maxFailures int) models.Domain {
+	domain.LastCheckedAt = &now
+
+	switch {
+	case lookupErr == nil:
+		if domain.Status != models.DomainVerified {
+			domain.VerifiedAt = &now
+		}
+		domain.Status = models.DomainVerified
+		domain.CheckError = ""
+		domain.CheckFailures = 0
+	case lookupErr == errChallengeNotFound:
+		domain.CheckError = fmt.Sprintf("%s: %s", challengeRecord(domain.Hostname), lookupErr)
+		if domain.Status == models.DomainVerified {
+			domain.CheckFailures++
+			if domain.CheckFailures >= maxFailures {
+				domain.Status = models.DomainFailed
+				domain.CheckFailures = 0
+			}
+		} else if domain.Status == models.DomainPending && now.Sub(verificationStart(domain)) > verifyTimeout {
+			domain.Status = models.DomainFailed
+		}
+	default:
+		domain.CheckError = fmt.Sprintf("DNS lookup failed: %s", lookupErr)
+	}
+
+	return domain
+}
+
+func verificationStart(domain models.Domain) time.Time {
+	if domain.VerificationStartedAt != nil {
+		return *domain.VerificationStartedAt
+	}
+	return domain.CreatedAt
+}
+
+// check the challenge record of the domain and save its new state
+func (app *app) checkDomain(ctx context.Context, domain models.Domain) (models.Domain, error) {
+	previousStatus := domain.Status
+	err := app.lookupChallenge(ctx, domain)
+	domain = verificationOutcome(domain, err, time.Now(), app.config.domainVerifyTimeout, app.config.domainFailures)
+	if domain.Status != previousStatus && domain.Status == models.DomainVerified {
+		app.infoLogger.Printf("domain %s of project %d is verified", domain.Hostname, domain.ProjectID)
+	} else if domain.Status != previousStatus && domain.Status == models.DomainFailed {
+		app.infoLogger.Printf("domain %s of project %d failed verification", domain.Hostname, domain.ProjectID)
+	}
+
+	updateErr := app.domainController.UpdateVerification(domain)
+	if updateErr != nil {
+		return domain, updateErr
+	}
+
+	// the domain starts or stops being routed
+	if previousStatus == models.DomainVerified && domain.Status != models.DomainVerified {
+		app.publishRouteChange(domain.ProjectID, "domain", domain.Hostname)
+	} else if previousStatus != models.DomainVerified && domain.Status == models.DomainVerified {
+		app.publishRouteChange(domain.ProjectID, "domain")
+	}
+
+	return domain, nil
+}
+
+// check every domain which is due
+func (app *app) checkDomains(ctx context.Context) {
+	domains, err := app.domainController.ListDueForCheck(time.Now().Add(-app.config.domainRecheckInterval))
+	if err != nil {
+		app.errorLogger.Println("unable to list the domains to verify", err)
+		return
+	}
+
+	for _, domain := range domains {
+		_, err := app.checkDomain(ctx, domain)
+		if err != nil {
+			app.errorLogger.Printf("unable to check the domain %s, %s", domain.Hostname, err)
+		}
+	}
+}
+
+// check the domains every interval until the context is done
+func (app *app) runDomainVerifier(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.checkDomains(ctx)
+		}
+	}
+}
+
+// check the challenge record of a domain now, a failed domain gets another verification window
+func (app *app) verifyDomainHandler(ctx *gin.Context) {
+	project, ok := app.ownedProject(ctx)
+	if !ok {
+		return
+	}
+
+	hostname, _ := normalizeHostname(ctx.Param("hostname"))
+	domain, err := app.domainController.Get(project.ID, hostname)
+	if err == models.ErrNoRecord {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"response": "Domain is not attached to the project.",
+		})
+		return
+	} else if err != nil {
+		app.errorLogger.Println("unable to query the domain", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	if domain.Status == models.DomainFailed {
+		now := time.Now()
+		domain.Status = models.DomainPending
+		domain.VerificationStartedAt = &now
+		domain.CheckFailures = 0
+		err = app.domainController.RestartVerification(domain.ID, now)
+		if err != nil {
+			app.errorLogger.Println("unable to restart the domain verification", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"response": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	domain, err = app.checkDomain(ctx.Request.Context(), domain)
+	if err != nil {
+		app.errorLogger.Println("unable to check the domain", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"response": "Internal Server Error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, domainResponse(domain, project))
+}
+
+// a domain with the DNS record the user has to create
+func domainResponse(domain models.Domain, project models.Project) gin.H {
+	return gin.H{
+		"hostname": domain.Hostname,
+		"primary":  domain.Hostname == project.Domain,
+		"status":   domain.Status,
+		"verification": gin.H{
+			"type":  "TXT",
+			"name":  challengeRecord(domain.Hostname),
+			"value": domain.VerificationToken,
+		},
+		"verifiedAt":    domain.VerifiedAt,
+		"lastCheckedAt": domain.LastCheckedAt,
+		"checkError":    domain.CheckError,
+	}
+}
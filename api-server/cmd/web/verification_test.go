@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+	"golang.org/x/net/dns/dnsmessage"
+	"gorm.io/gorm"
+)
+
+func TestVerificationOutcome(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	recent := now.Add(-time.Hour)
+	old := now.Add(-100 * time.Hour)
+	lookupFailed := errors.New("i/o timeout")
+
+	tests := []struct {
+		name         string
+		domain       models.Domain
+		lookupErr    error
+		wantStatus   string
+		wantFailures int
+		wantVerified bool
+	}{
+		{"pending found", models.Domain{Status: models.DomainPending, VerificationStartedAt: &recent}, nil, models.DomainVerified, 0, true},
+		{"pending missing", models.Domain{Status: models.DomainPending, VerificationStartedAt: &recent}, errChallengeNotFound, models.DomainPending, 0, false},
+		{"pending missing after the window", models.Domain{Status: models.DomainPending, VerificationStartedAt: &old}, errChallengeNotFound, models.DomainFailed, 0, false},
+		{"pending restarted after the creation", models.Domain{Model: gorm.Model{CreatedAt: old}, Status: models.DomainPending, VerificationStartedAt: &recent}, errChallengeNotFound, models.DomainPending, 0, false},
+		{"pending without a window start", models.Domain{Model: gorm.Model{CreatedAt: old}, Status: models.DomainPending}, errChallengeNotFound, models.DomainFailed, 0, false},
+		{"pending lookup error after the window", models.Domain{Status: models.DomainPending, VerificationStartedAt: &old}, lookupFailed, models.DomainPending, 0, false},
+		{"verified found", models.Domain{Status: models.DomainVerified, VerifiedAt: &old, CheckFailures: 2}, nil, models.DomainVerified, 0, true},
+		{"verified missing once", models.Domain{Status: models.DomainVerified, VerifiedAt: &old}, errChallengeNotFound, models.DomainVerified, 1, true},
+		{"verified missing twice", models.Domain{Status: models.DomainVerified, VerifiedAt: &old, CheckFailures: 1}, errChallengeNotFound, models.DomainVerified, 2, true},
+		{"verified missing three times", models.Domain{Status: models.DomainVerified, VerifiedAt: &old, CheckFailures: 2}, errChallengeNotFound, models.DomainFailed, 0, true},
+		{"verified lookup error", models.Domain{Status: models.DomainVerified, VerifiedAt: &old, CheckFailures: 2}, lookupFailed, models.DomainVerified, 2, true},
+		{"failed missing", models.Domain{Status: models.DomainFailed, VerificationStartedAt: &old}, errChallengeNotFound, models.DomainFailed, 0, false},
+	}
+
+	for _, test := range tests {
+		got := verificationOutcome(test.domain, test.lookupErr, now, 72*time.Hour, 3)
+		if got.Status != test.wantStatus || got.CheckFailures != test.wantFailures {
+			t.Errorf("%s: status %s with %d failures, want %s with %d failures", test.name, got.Status, got.CheckFailures, test.wantStatus, test.wantFailures)
+		}
+		if (got.VerifiedAt != nil) != test.wantVerified {
+			t.Errorf("%s: verifiedAt %v", test.name, got.VerifiedAt)
+		}
+		if got.LastCheckedAt == nil || !got.LastCheckedAt.Equal(now) {
+			t.Errorf("%s: lastCheckedAt %v, want %v", test.name, got.LastCheckedAt, now)
+		}
+		if (test.lookupErr == nil) != (got.CheckError == "") {
+			t.Errorf("%s: checkError %q", test.name, got.CheckError)
+		}
+	}
+}
+
+// serveDNS answers the TXT queries of records on a local UDP port, unknown names are NXDOMAIN
+// and names of servfail.com fail
+func serveDNS(t *testing.T, records map[string][]string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+
+			var parser dnsmessage.Parser
+			header, err := parser.Start(buffer[:n])
+			if err != nil {
+				continue
+			}
+			question, err := parser.Question()
+			if err != nil {
+				continue
+			}
+
+			name := strings.TrimSuffix(question.Name.String(), ".")
+			values, found := records[name]
+			responseHeader := dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true}
+			switch {
+			case strings.HasSuffix(name, "servfail.com"):
+				responseHeader.RCode = dnsmessage.RCodeServerFailure
+			case !found:
+				responseHeader.RCode = dnsmessage.RCodeNameError
+			}
+
+			builder := dnsmessage.NewBuilder(nil, responseHeader)
+			builder.StartQuestions()
+			builder.Question(question)
+			builder.StartAnswers()
+			if found && question.Type == dnsmessage.TypeTXT {
+				for _, value := range values {
+					builder.TXTResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.TXTResource{TXT: []string{value}})
+				}
+			}
+			response, err := builder.Finish()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(response, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestLookupChallenge(t *testing.T) {
+	address := serveDNS(t, map[string][]string{
+		"_scale-mesh-challenge.example.com":  {"other", "token123"},
+		"_scale-mesh-challenge.example.org":  {"wrong"},
+		"_scale-mesh-challenge.servfail.com": {"token123"},
+	})
+	app := &app{resolver: newResolver(address)}
+
+	tests := []struct {
+		hostname string
+		want     error
+	}{
+		{"example.com", nil},
+		{"example.org", errChallengeNotFound},
+		{"missing.example.net", errChallengeNotFound},
+	}
+
+	for _, test := range tests {
+		err := app.lookupChallenge(context.Background(), models.Domain{Hostname: test.hostname, VerificationToken: "token123"})
+		if err != test.want {
+			t.Errorf("lookupChallenge(%q) = %v, want %v", test.hostname, err, test.want)
+		}
+	}
+
+	// a failing DNS server is not a missing record
+	err := app.lookupChallenge(context.Background(), models.Domain{Hostname: "servfail.com", VerificationToken: "token123"})
+	if err == nil || err == errChallengeNotFound {
+		t.Errorf("lookupChallenge(%q) = %v, want a lookup error", "servfail.com", err)
+	}
+}
@@ -5,6 +5,7 @@ package models
 
 import (
 	"errors"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -19,11 +20,75 @@ const (
 	FAIL
 )
 
+var statusNames = map[Status]string{
+	QUEUE:    "QUEUE",
+	PROGRESS: "PROGRESS",
+	READY:    "READY",
+	FAIL:     "FAIL",
+}
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// ParseStatus converts the status name reported by the build server into a Status.
+func ParseStatus(name string) (Status, error) {
+	for status, statusName := range statusNames {
+		if statusName == name {
+			return status, nil
+		}
+	}
+	return QUEUE, ErrInvalidStatus
+}
+
 // For custom errors
 var (
 	ErrNoRecord           = errors.New("MODELS: no matching record found")
 	ErrInvalidCredentials = errors.New("MODELS: invalid credentials")
 	ErrDuplicateEmails    = errors.New("MODELS: email already exists")
+	ErrInvalidStatus      = errors.New("MODELS: invalid deployment status")
+	ErrDuplicateDomain    = errors.New("MODELS: domain is already attached to a project")
+	ErrDuplicateSlug      = errors.New("MODELS: slug is already used by a project")
+)
+
+// Plan bounds the build output and the artifact storage of a user
+type Plan struct {
+	Name          string `json:"name"`
+	StorageQuota  int64  `json:"storageQuota"`  // bytes of artifacts kept over all projects
+	MaxFiles      int    `json:"maxFiles"`      // files in the output of a build
+	MaxFileSize   int64  `json:"maxFileSize"`   // bytes of a single file
+	MaxOutputSize int64  `json:"maxOutputSize"` // bytes of the output of a build
+}
+
+const DefaultPlan = "free"
+
+var Plans = map[string]Plan{
+	"free": {Name: "free", StorageQuota: 1 << 30, MaxFiles: 10000, MaxFileSize: 25 << 20, MaxOutputSize: 500 << 20},
+	"pro":  {Name: "pro", StorageQuota: 50 << 30, MaxFiles: 50000, MaxFileSize: 100 << 20, MaxOutputSize: 2 << 30},
+}
+
+// secret scan policies of a project
+const (
+	SecretScanBlock = "block"
+	SecretScanWarn  = "warn"
+	SecretScanOff   = "off"
+)
+
+// runtimes of a project
+const (
+	RuntimeStatic = "static" // the build output is served from the artifact storage
+	RuntimeApp    = "app"    // the built app runs as a server on the app runner
+)
+
+// access modes of a site
+const (
+	AccessPublic   = "public"
+	AccessBasic    = "basic"    // HTTP basic auth with the project user name and password
+	AccessPassword = "password" // login page with the shared project password
+	AccessTeam     = "team"     // Scale Mesh users with access to the project
 )
 
 type User struct {
@@ -33,17 +98,68 @@ type User struct {
 	Email          string `gorm:"unique"`
 	HashedPassword string
 	Projects       []Project `gorm:"foreignKey:UserID"`
+	Plan           string    `gorm:"default:free"`
+}
+
+// plan of the user, unknown plans fall back to the default one
+func (user *User) CurrentPlan() Plan {
+	if plan, ok := Plans[user.Plan]; ok {
+		return plan
+	}
+	return Plans[DefaultPlan]
 }
 
 type Project struct {
 	gorm.Model
 	ID          uint `gorm:"primaryKey"`
 	Name        string
+	Slug        string `gorm:"uniqueIndex"` // subdomain of the site, {Slug}.{baseDomain}
 	GitUrl      string
-	Domain      string
+	Domain      string       // primary custom hostname, one of Domains
 	UserID      uint         // foreign key to User
 	User        User         `gorm:"constraint:OnDelete:CASCADE;"`
 	Deployments []Deployment `gorm:"foreignKey:ProjectID"`
+	Domains     []Domain     `gorm:"foreignKey:ProjectID"`
+	// deployment currently served for the project, 0 until the first build is ready
+	LiveDeploymentID uint
+	// deployment served to CanaryWeight percent of the visitors next to the live one, 0 without a canary
+	CanaryDeploymentID uint
+	CanaryWeight       int
+	// artifact retention policy, 0 uses the platform default
+	KeepDeployments int // keep the artifacts of the last N ready deployments
+	KeepDays        int // keep the artifacts of deployments created in the last M days
+	// what the build server does when the secret scan finds something: block, warn or off
+	SecretScanPolicy string `gorm:"default:block"`
+	// static or app, an app is started with StartCommand and is up once HealthCheckPath answers
+	Runtime         string `gorm:"default:static"`
+	StartCommand    string // npm start if empty
+	HealthCheckPath string // / if empty
+	// path resolution of the reverse proxy
+	SPAFallback bool // serve index.html for unknown paths, for client side routing
+	CleanURLs   bool // serve /about from about.html or about/index.html
+	// who the reverse proxy lets see the site: public, basic, password or team
+	AccessMode         string `gorm:"default:public"`
+	AccessUsername     string // user name of the basic auth
+	AccessPasswordHash string // bcrypt hash of the basic auth or login page password
+	// requests per second the reverse proxy accepts, 0 uses the platform default
+	RateLimit      int // from all clients
+	RateLimitPerIP int // from one client IP
+	// security headers the reverse proxy adds to every response, empty keeps the platform default, off sends none
+	ContentSecurityPolicy   string
+	StrictTransportSecurity string
+	ReferrerPolicy          string
+	PermissionsPolicy       string
+	FrameOptions            string
+	// comma separated origins allowed to read the site with CORS, * for any
+	CORSOrigins string
+	// a site in maintenance is served a 503 page, except to the allowed client IPs
+	Maintenance           bool
+	MaintenanceMessage    string
+	MaintenanceRetryAfter int    // seconds, 0 uses the default of the proxy
+	MaintenanceAllowedIPs string // comma separated addresses or CIDRs
+	// a suspended site is served a 503 page to everyone, only the platform lifts the suspension
+	Suspended         bool
+	SuspensionMessage string
 }
 
 type Deployment struct {
@@ -51,7 +167,74 @@ type Deployment struct {
 	ID        uint    `gorm:"primaryKey"`
 	ProjectID uint    // foreign key to Project
 	Project   Project `gorm:"constraint:OnDelete:CASCADE;"`
-	// Status    Status
+	Status    Status
+	Message   string // reason reported by the build server when the deployment fails
+	// totals from the artifact manifest, set once the build is ready
+	TotalFiles int
+	TotalSize  int64
+	StoredSize int64 // bytes in the bucket including the compressed variants
+	// set once the garbage collector deleted the artifacts of the deployment
+	ArtifactsDeletedAt *time.Time
+	// overrides the access mode of the project while the deployment is served, empty inherits it
+	AccessMode string
+	// once ready the deployment becomes the canary of the project for this percentage of the visitors
+	// instead of going live, 0 promotes it right away
+	CanaryWeight int
+}
+
+// verification states of a custom domain
+const (
+	DomainPending  = "pending"
+	DomainVerified = "verified"
+	DomainFailed   = "failed"
+)
+
+// Domain is a custom hostname attached to a project, it is routed once verified
+type Domain struct {
+	gorm.Model
+	ID        uint    `gorm:"primaryKey"`
+	Hostname  string  `gorm:"uniqueIndex"`
+	ProjectID uint    // foreign key to Project
+	Project   Project `gorm:"constraint:OnDelete:CASCADE;"`
+	// ownership is proven with a TXT record _scale-mesh-challenge.{Hostname} holding the token
+	Status            string `gorm:"default:pending;index"`
+	VerificationToken string
+	// a pending domain fails once its verification window is over, domains attached before the
+	// column existed start it at their creation
+	VerificationStartedAt *time.Time
+	VerifiedAt            *time.Time
+	LastCheckedAt         *time.Time
+	CheckError            string // why the last check didn't verify the domain
+	CheckFailures         int    // checks in a row which didn't find the record of a verified domain
+}
+
+// TrafficStat counts the requests the reverse proxies served for a project in an hour
+type TrafficStat struct {
+	ID        uint      `gorm:"primaryKey"`
+	ProjectID uint      `gorm:"uniqueIndex:idx_traffic_stat_hour"`
+	Hour      time.Time `gorm:"uniqueIndex:idx_traffic_stat_hour"`
+	Requests  int64
+	Bytes     int64
+	Status2xx int64
+	Status3xx int64
+	Status4xx int64
+	Status5xx int64
+	LatencyMs int64 // sum over the requests
+}
+
+// TrafficPath counts the requests of a path of a project in an hour
+type TrafficPath struct {
+	ID        uint      `gorm:"primaryKey"`
+	ProjectID uint      `gorm:"uniqueIndex:idx_traffic_path_hour"`
+	Hour      time.Time `gorm:"uniqueIndex:idx_traffic_path_hour"`
+	Path      string    `gorm:"uniqueIndex:idx_traffic_path_hour"`
+	Requests  int64
+}
+
+// PathRequests is a path with its requests over a time range
+type PathRequests struct {
+	Path     string `json:"path"`
+	Requests int64  `json:"requests"`
 }
 
 type LoginUser struct {
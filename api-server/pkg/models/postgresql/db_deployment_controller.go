@@ -1,6 +1,8 @@
 package postgresql
 
 import (
+	"time"
+
 	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
 	"gorm.io/gorm"
 )
@@ -13,8 +15,149 @@ func (dc *DeploymentController) Insert(deployement models.Deployment) (int, erro
 	result := dc.DatabaseConnectionPool.Create(&deployement)
 
 	if result.Error != nil {
-		return 0, nil
+		return 0, result.Error
 	}
 
 	return int(deployement.ID), nil
 }
+
+// InsertMigrated records the site a project had before every deployment had its own artifact prefix, as a
+// deployment in progress. Its ID is above every project ID, so its prefix is none of the legacy __output/{projectID}/
+func (dc *DeploymentController) InsertMigrated(projectID uint) (uint, error) {
+	deployment := models.Deployment{ProjectID: projectID, Status: models.PROGRESS}
+	err := dc.DatabaseConnectionPool.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`SELECT setval(pg_get_serial_sequence('deployments', 'id'), GREATEST(
+			(SELECT COALESCE(MAX(id), 0) FROM deployments), (SELECT COALESCE(MAX(id), 0) FROM projects), 1))`).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&deployment).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deployment.ID, nil
+}
+
+// fetch a deployment along with the project it belongs to.
+func (dc *DeploymentController) Get(id int) (models.Deployment, error) {
+	var deployment models.Deployment
+	result := dc.DatabaseConnectionPool.Preload("Project").First(&deployment, id)
+
+	if result.Error == gorm.ErrRecordNotFound {
+		return deployment, models.ErrNoRecord
+	} else if result.Error != nil {
+		return deployment, result.Error
+	}
+
+	return deployment, nil
+}
+
+// update the build status, the failure message and the artifact totals of a deployment.
+func (dc *DeploymentController) UpdateStatus(id int, status models.Status, message string, totalFiles int, totalSize, storedSize int64) error {
+	result := dc.DatabaseConnectionPool.Model(&models.Deployment{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":      status,
+		"message":     message,
+		"total_files": totalFiles,
+		"total_size":  totalSize,
+		"stored_size": storedSize,
+	})
+
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// fail a deployment after its build, keeping the totals reported by the build server
+func (dc *DeploymentController) Fail(id uint, message string) error {
+	result := dc.DatabaseConnectionPool.Model(&models.Deployment{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":  models.FAIL,
+		"message": message,
+	})
+
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// deployments of a project, newest first
+func (dc *DeploymentController) ListByProject(projectID uint) ([]models.Deployment, error) {
+	var deployments []models.Deployment
+	result := dc.DatabaseConnectionPool.Where("project_id = ?", projectID).Order("created_at DESC").Find(&deployments)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return deployments, nil
+}
+
+// deployments of a project whose artifacts still exist, newest first
+func (dc *DeploymentController) ListWithArtifacts(projectID uint) ([]models.Deployment, error) {
+	var deployments []models.Deployment
+	result := dc.DatabaseConnectionPool.Where("project_id = ? AND artifacts_deleted_at IS NULL", projectID).Order("created_at DESC").Find(&deployments)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return deployments, nil
+}
+
+func (dc *DeploymentController) MarkArtifactsDeleted(id uint) error {
+	now := time.Now()
+	result := dc.DatabaseConnectionPool.Model(&models.Deployment{}).Where("id = ?", id).Update("artifacts_deleted_at", &now)
+
+	return result.Error
+}
+
+// bytes of artifacts kept for every project of the user
+func (dc *DeploymentController) StorageUsedByUser(userID uint) (int64, error) {
+	var used int64
+	result := dc.DatabaseConnectionPool.Model(&models.Deployment{}).
+		Joins("JOIN projects ON projects.id = deployments.project_id").
+		Where("projects.user_id = ? AND projects.deleted_at IS NULL AND deployments.artifacts_deleted_at IS NULL", userID).
+		Select("COALESCE(SUM(deployments.stored_size), 0)").
+		Scan(&used)
+
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return used, nil
+}
+
+// access mode overrides of the deployments by ID, deployments without one are left out
+func (dc *DeploymentController) AccessModes(ids []uint) (map[uint]string, error) {
+	var deployments []models.Deployment
+	result := dc.DatabaseConnectionPool.Select("id", "access_mode").Where("id IN ? AND access_mode <> ''", ids).Find(&deployments)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	modes := make(map[uint]string, len(deployments))
+	for _, deployment := range deployments {
+		modes[deployment.ID] = deployment.AccessMode
+	}
+
+	return modes, nil
+}
+
+func (dc *DeploymentController) SetAccessMode(id uint, mode string) error {
+	result := dc.DatabaseConnectionPool.Model(&models.Deployment{}).Where("id = ?", id).Update("access_mode", mode)
+
+	return result.Error
+}
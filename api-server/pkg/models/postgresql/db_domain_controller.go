@@ -0,0 +1,170 @@
+package postgresql
+
+import (
+	"errors"
+	"time"
+
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DomainController struct {
+	DatabaseConnectionPool *gorm.DB
+}
+
+// attach the domain to its project, a pending or failed claim of another project is replaced
+// since only a verified domain proves its ownership
+func (dc *DomainController) Insert(domain models.Domain) (models.Domain, error) {
+	err := dc.DatabaseConnectionPool.Transaction(func(tx *gorm.DB) error {
+		var existing models.Domain
+		result := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Where("hostname = ?", domain.Hostname).Limit(1).Find(&existing)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			if existing.Status == models.DomainVerified || existing.ProjectID == domain.ProjectID {
+				return models.ErrDuplicateDomain
+			}
+			result = tx.Unscoped().Delete(&existing)
+			if result.Error != nil {
+				return result.Error
+			}
+			err := setPrimaryDomain(tx, existing.ProjectID)
+			if err != nil {
+				return err
+			}
+		}
+
+		result = tx.Create(&domain)
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return models.ErrDuplicateDomain
+		}
+		return result.Error
+	})
+
+	return domain, err
+}
+
+// the oldest verified domain of the project is its primary domain
+func setPrimaryDomain(tx *gorm.DB, projectID uint) error {
+	var primary models.Domain
+	result := tx.Where("project_id = ? AND status = ?", projectID, models.DomainVerified).Order("created_at").Limit(1).Find(&primary)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	result = tx.Model(&models.Project{}).Where("id = ?", projectID).Update("domain", primary.Hostname)
+	return result.Error
+}
+
+func (dc *DomainController) ListByProject(projectID uint) ([]models.Domain, error) {
+	var domains []models.Domain
+	result := dc.DatabaseConnectionPool.Where("project_id = ?", projectID).Order("created_at").Find(&domains)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return domains, nil
+}
+
+// fetch a verified domain with its project
+func (dc *DomainController) GetVerifiedByHostname(hostname string) (models.Domain, error) {
+	var domain models.Domain
+	result := dc.DatabaseConnectionPool.Preload("Project").Where("hostname = ? AND status = ?", hostname, models.DomainVerified).First(&domain)
+
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return domain, models.ErrNoRecord
+	} else if result.Error != nil {
+		return domain, result.Error
+	}
+
+	return domain, nil
+}
+
+// detach the domain from the project, the next oldest verified domain becomes the primary one
+func (dc *DomainController) Delete(projectID uint, hostname string) error {
+	return dc.DatabaseConnectionPool.Transaction(func(tx *gorm.DB) error {
+		// hard delete so the hostname can be attached again
+		result := tx.Unscoped().Where("project_id = ? AND hostname = ?", projectID, hostname).Delete(&models.Domain{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return models.ErrNoRecord
+		}
+
+		return setPrimaryDomain(tx, projectID)
+	})
+}
+
+func (dc *DomainController) Get(projectID uint, hostname string) (models.Domain, error) {
+	var domain models.Domain
+	result := dc.DatabaseConnectionPool.Where("project_id = ? AND hostname = ?", projectID, hostname).First(&domain)
+
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return domain, models.ErrNoRecord
+	} else if result.Error != nil {
+		return domain, result.Error
+	}
+
+	return domain, nil
+}
+
+// domains due for a check: every pending domain, verified domains whose last check didn't find
+// the record and verified domains last checked before recheckBefore
+func (dc *DomainController) ListDueForCheck(recheckBefore time.Time) ([]models.Domain, error) {
+	var domains []models.Domain
+	result := dc.DatabaseConnectionPool.
+		Where("status = ?", models.DomainPending).
+		Or("status = ? AND (check_failures > 0 OR last_checked_at IS NULL OR last_checked_at < ?)", models.DomainVerified, recheckBefore).
+		Find(&domains)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return domains, nil
+}
+
+// save the outcome of a verification check, the primary domain of the project follows the verified ones
+func (dc *DomainController) UpdateVerification(domain models.Domain) error {
+	return dc.DatabaseConnectionPool.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Domain{}).Where("id = ?", domain.ID).Updates(map[string]interface{}{
+			"status":          domain.Status,
+			"verified_at":     domain.VerifiedAt,
+			"last_checked_at": domain.LastCheckedAt,
+			"check_error":     domain.CheckError,
+			"check_failures":  domain.CheckFailures,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return setPrimaryDomain(tx, domain.ProjectID)
+	})
+}
+
+// move a failed domain back to pending, the verification window starts again at startedAt
+func (dc *DomainController) RestartVerification(id uint, startedAt time.Time) error {
+	result := dc.DatabaseConnectionPool.Model(&models.Domain{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":                  models.DomainPending,
+		"verification_started_at": startedAt,
+		"check_failures":          0,
+	})
+
+	return result.Error
+}
+
+// every verified domain, the reverse proxies resync their routes with them
+func (dc *DomainController) ListVerified() ([]models.Domain, error) {
+	var domains []models.Domain
+	result := dc.DatabaseConnectionPool.Where("status = ?", models.DomainVerified).Find(&domains)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return domains, nil
+}
@@ -1,6 +1,8 @@
 package postgresql
 
 import (
+	"errors"
+
 	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
 	"gorm.io/gorm"
 )
@@ -10,10 +12,21 @@ type ProjectModel struct {
 }
 
 func (projectModel *ProjectModel) Insert(project models.Project) (int, error) {
+	taken, err := projectModel.SlugTaken(project.Slug, 0)
+	if err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, models.ErrDuplicateSlug
+	}
+
+	// a project created with the same slug since the check loses on the unique index
 	result := projectModel.DBConnectionPool.Create(&project)
 
-	if result.Error != nil {
-		return 0, nil
+	if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+		return 0, models.ErrDuplicateSlug
+	} else if result.Error != nil {
+		return 0, result.Error
 	}
 
 	return int(project.ID), nil
@@ -36,3 +49,146 @@ func (projectModel *ProjectModel) CheckExistingProject(id int) (models.Project,
 
 	return project, nil
 }
+
+// point the project at the deployment which should be served, to every visitor so a running canary ends.
+func (projectModel *ProjectModel) SetLiveDeployment(projectID, deploymentID uint) error {
+	result := projectModel.DBConnectionPool.Model(&models.Project{}).Where("id = ?", projectID).Updates(map[string]interface{}{
+		"live_deployment_id":   deploymentID,
+		"canary_deployment_id": 0,
+		"canary_weight":        0,
+	})
+
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// serve the deployment to weight percent of the visitors of the project
+func (projectModel *ProjectModel) SetCanary(projectID, deploymentID uint, weight int) error {
+	result := projectModel.DBConnectionPool.Model(&models.Project{}).Where("id = ?", projectID).Updates(map[string]interface{}{
+		"canary_deployment_id": deploymentID,
+		"canary_weight":        weight,
+	})
+
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// make the canary the live deployment of the project, for every visitor
+func (projectModel *ProjectModel) PromoteCanary(projectID uint) error {
+	result := projectModel.DBConnectionPool.Model(&models.Project{}).Where("id = ? AND canary_deployment_id <> 0", projectID).Updates(map[string]interface{}{
+		"live_deployment_id":   gorm.Expr("canary_deployment_id"),
+		"canary_deployment_id": 0,
+		"canary_weight":        0,
+	})
+
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// fetch every project, used by the background jobs
+func (projectModel *ProjectModel) All() ([]models.Project, error) {
+	var projects []models.Project
+	result := projectModel.DBConnectionPool.Find(&projects)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return projects, nil
+}
+
+// update the columns of the project settings
+func (projectModel *ProjectModel) UpdateSettings(id uint, settings map[string]interface{}) error {
+	result := projectModel.DBConnectionPool.Model(&models.Project{}).Where("id = ?", id).Updates(settings)
+
+	// the slug is the only unique setting
+	if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+		return models.ErrDuplicateSlug
+	} else if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+func (projectModel *ProjectModel) GetBySlug(slug string) (models.Project, error) {
+	var project models.Project
+	result := projectModel.DBConnectionPool.Where("slug = ?", slug).First(&project)
+
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return project, models.ErrNoRecord
+	} else if result.Error != nil {
+		return project, result.Error
+	}
+
+	return project, nil
+}
+
+// whether another project than exceptID uses the slug, deleted projects keep their slug
+func (projectModel *ProjectModel) SlugTaken(slug string, exceptID uint) (bool, error) {
+	var count int64
+	result := projectModel.DBConnectionPool.Unscoped().Model(&models.Project{}).Where("slug = ? AND id <> ?", slug, exceptID).Count(&count)
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return count > 0, nil
+}
+
+// projects created before slugs existed
+func (projectModel *ProjectModel) ListWithoutSlug() ([]models.Project, error) {
+	var projects []models.Project
+	result := projectModel.DBConnectionPool.Where("slug IS NULL OR slug = ''").Find(&projects)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return projects, nil
+}
+
+// projects without a live deployment
+func (projectModel *ProjectModel) ListNotLive() ([]models.Project, error) {
+	var projects []models.Project
+	result := projectModel.DBConnectionPool.Where("live_deployment_id = 0 OR live_deployment_id IS NULL").Find(&projects)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return projects, nil
+}
+
+// projects with a live deployment
+func (projectModel *ProjectModel) ListLive() ([]models.Project, error) {
+	var projects []models.Project
+	result := projectModel.DBConnectionPool.Where("live_deployment_id > 0").Find(&projects)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return projects, nil
+}
@@ -0,0 +1,91 @@
+package postgresql
+
+import (
+	"time"
+
+	"gitlab.com/harisheoran/scale-mesh/api-server/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TrafficController struct {
+	DatabaseConnectionPool *gorm.DB
+}
+
+// add the counters to the stored ones of the same project and hour
+func (tc *TrafficController) Record(stats []models.TrafficStat, paths []models.TrafficPath) error {
+	return tc.DatabaseConnectionPool.Transaction(func(tx *gorm.DB) error {
+		if len(stats) > 0 {
+			result := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "project_id"}, {Name: "hour"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"requests":   gorm.Expr("traffic_stats.requests + excluded.requests"),
+					"bytes":      gorm.Expr("traffic_stats.bytes + excluded.bytes"),
+					"status2xx":  gorm.Expr("traffic_stats.status2xx + excluded.status2xx"),
+					"status3xx":  gorm.Expr("traffic_stats.status3xx + excluded.status3xx"),
+					"status4xx":  gorm.Expr("traffic_stats.status4xx + excluded.status4xx"),
+					"status5xx":  gorm.Expr("traffic_stats.status5xx + excluded.status5xx"),
+					"latency_ms": gorm.Expr("traffic_stats.latency_ms + excluded.latency_ms"),
+				}),
+			}).Create(&stats)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		if len(paths) > 0 {
+			result := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "project_id"}, {Name: "hour"}, {Name: "path"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"requests": gorm.Expr("traffic_paths.requests + excluded.requests"),
+				}),
+			}).CreateInBatches(&paths, 500)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		return nil
+	})
+}
+
+// hourly counters of the project in [from, to)
+func (tc *TrafficController) ListStats(projectID uint, from, to time.Time) ([]models.TrafficStat, error) {
+	var stats []models.TrafficStat
+	result := tc.DatabaseConnectionPool.Where("project_id = ? AND hour >= ? AND hour < ?", projectID, from, to).Order("hour").Find(&stats)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return stats, nil
+}
+
+// the most requested paths of the project in [from, to)
+func (tc *TrafficController) TopPaths(projectID uint, from, to time.Time, limit int) ([]models.PathRequests, error) {
+	var paths []models.PathRequests
+	result := tc.DatabaseConnectionPool.Model(&models.TrafficPath{}).
+		Select("path, SUM(requests) AS requests").
+		Where("project_id = ? AND hour >= ? AND hour < ?", projectID, from, to).
+		Group("path").
+		Order("requests DESC, path").
+		Limit(limit).
+		Scan(&paths)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return paths, nil
+}
+
+// delete the counters older than the time, returns how many hours of counters were deleted
+func (tc *TrafficController) DeleteBefore(before time.Time) (int64, error) {
+	result := tc.DatabaseConnectionPool.Where("hour < ?", before).Delete(&models.TrafficPath{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	result = tc.DatabaseConnectionPool.Where("hour < ?", before).Delete(&models.TrafficStat{})
+	return result.RowsAffected, result.Error
+}
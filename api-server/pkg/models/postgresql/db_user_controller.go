@@ -51,6 +51,15 @@ func (uc *UserDBController) Authenticate(email, password string) (int, error) {
 
 // fetch user details
 func (uc *UserDBController) GetUser(id int) (*models.User, error) {
+	user := models.User{}
+	result := uc.DatabaseConnectionPool.First(&user, id)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, result.Error
+	}
 
-	return nil, nil
+	return &user, nil
 }
@@ -0,0 +1,23 @@
+package storage
+
+import "time"
+
+// Manifest lists what a deployment contains, it is written by the build server.
+type Manifest struct {
+	ProjectID    string         `json:"projectId"`
+	DeploymentID string         `json:"deploymentId"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	TotalFiles   int            `json:"totalFiles"`
+	TotalSize    int64          `json:"totalSize"`
+	StoredSize   int64          `json:"storedSize"` // total size including the compressed variants
+	Files        []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"contentType"`
+	// size of the pre-compressed siblings by encoding, "gzip" is stored as path.gz and "br" as path.br
+	Encodings map[string]int64 `json:"encodings,omitempty"`
+}
@@ -0,0 +1,290 @@
+/*
+Access to the build artifacts which the build server uploads to the S3 bucket.
+*/
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var ErrNotFound = errors.New("STORAGE: object not found")
+
+// ArtifactPrefix is the key prefix under which every file of a deployment is stored.
+func ArtifactPrefix(deploymentID uint) string {
+	return fmt.Sprintf("__output/%d/", deploymentID)
+}
+
+// LegacyArtifactPrefix is where the artifacts of a project were stored before every deployment had its own prefix.
+func LegacyArtifactPrefix(projectID uint) string {
+	return fmt.Sprintf("__output/%d/", projectID)
+}
+
+// ManifestKey is the key of the manifest written beside the artifacts of a deployment.
+func ManifestKey(deploymentID uint) string {
+	return fmt.Sprintf("__output/%d.manifest.json", deploymentID)
+}
+
+// AppArchiveKey is the key of the packed build of an app deployment, it stays private.
+func AppArchiveKey(deploymentID uint) string {
+	return fmt.Sprintf("__apps/%d.tar.gz", deploymentID)
+}
+
+type S3Store struct {
+	Client *s3.Client
+	Bucket string
+}
+
+func NewS3Store(ctx context.Context, region, bucket string) (*S3Store, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Store{
+		Client: s3.NewFromConfig(cfg),
+		Bucket: bucket,
+	}, nil
+}
+
+// read and decode the manifest of a deployment.
+func (store *S3Store) ReadManifest(ctx context.Context, deploymentID uint) (*Manifest, error) {
+	output, err := store.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(ManifestKey(deploymentID)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	manifest := &Manifest{}
+	err = json.NewDecoder(output.Body).Decode(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// delete every artifact and the manifest of a deployment, returns the number of deleted objects
+func (store *S3Store) DeleteDeployment(ctx context.Context, deploymentID uint) (int, error) {
+	deleted, err := store.DeletePrefix(ctx, ArtifactPrefix(deploymentID))
+	if err != nil {
+		return deleted, err
+	}
+
+	_, err = store.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(ManifestKey(deploymentID)),
+	})
+	if err != nil {
+		return deleted, err
+	}
+
+	// the archive of an app deployment, deleting a missing key succeeds as well
+	_, err = store.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(AppArchiveKey(deploymentID)),
+	})
+	if err != nil {
+		return deleted + 1, err
+	}
+
+	return deleted + 1, nil
+}
+
+// presigned URL the app runner downloads the archive of an app deployment from, valid for ttl
+func (store *S3Store) PresignAppArchive(ctx context.Context, deploymentID uint, ttl time.Duration) (string, error) {
+	request, err := s3.NewPresignClient(store.Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(AppArchiveKey(deploymentID)),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return request.URL, nil
+}
+
+// delete every object under the prefix, returns the number of deleted objects
+func (store *S3Store) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	deleted := 0
+	paginator := s3.NewListObjectsV2Paginator(store.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(store.Bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return deleted, err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: object.Key})
+		}
+
+		// a page holds at most 1000 keys, which is also the limit of a batch delete
+		output, err := store.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(store.Bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if len(output.Errors) > 0 {
+			return deleted, fmt.Errorf("unable to delete %s, %s", aws.ToString(output.Errors[0].Key), aws.ToString(output.Errors[0].Message))
+		}
+
+		deleted += len(objects)
+	}
+
+	return deleted, nil
+}
+
+// HasObjects reports whether any object is stored under the prefix
+func (store *S3Store) HasObjects(ctx context.Context, prefix string) (bool, error) {
+	output, err := store.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(store.Bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(output.Contents) > 0, nil
+}
+
+// Exists reports whether the object is stored
+func (store *S3Store) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := store.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CopyLegacyArtifacts copies the artifacts of a project from its legacy prefix to the prefix of the
+// deployment and writes their manifest. The legacy objects are left for the caller to delete.
+func (store *S3Store) CopyLegacyArtifacts(ctx context.Context, projectID, deploymentID uint) (*Manifest, error) {
+	legacyPrefix := LegacyArtifactPrefix(projectID)
+	manifest := &Manifest{
+		ProjectID:    strconv.FormatUint(uint64(projectID), 10),
+		DeploymentID: strconv.FormatUint(uint64(deploymentID), 10),
+		CreatedAt:    time.Now().UTC(),
+		Files:        []ManifestFile{},
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(store.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(store.Bucket),
+		Prefix: aws.String(legacyPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			path := strings.TrimPrefix(key, legacyPrefix)
+			if path == "" || strings.HasSuffix(path, "/") {
+				continue
+			}
+
+			// the legacy uploads have no checksum, it is computed from the object
+			file, err := store.hashObject(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			file.Path = path
+
+			_, err = store.Client.CopyObject(ctx, &s3.CopyObjectInput{
+				Bucket:     aws.String(store.Bucket),
+				CopySource: aws.String((&url.URL{Path: store.Bucket + "/" + key}).EscapedPath()),
+				Key:        aws.String(ArtifactPrefix(deploymentID) + path),
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			manifest.Files = append(manifest.Files, file)
+			manifest.TotalFiles++
+			manifest.TotalSize += file.Size
+		}
+	}
+	manifest.StoredSize = manifest.TotalSize
+
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	_, err = store.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(store.Bucket),
+		Key:         aws.String(ManifestKey(deploymentID)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// size, SHA-256 and content type of an object, read from its content
+func (store *S3Store) hashObject(ctx context.Context, key string) (ManifestFile, error) {
+	output, err := store.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer output.Body.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, output.Body)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+
+	return ManifestFile{
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		ContentType: aws.ToString(output.ContentType),
+	}, nil
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	appStarting   = "starting"
+	appRunning    = "running"
+	appRestarting = "restarting"
+	appFailed     = "failed"
+	appStopped    = "stopped"
+)
+
+var (
+	errNoPort       = errors.New("no free port left for the app")
+	errStartTimeout = errors.New("the app did not pass its health check in time")
+)
+
+// spec of an app deployment, as sent by the api server and kept in the state file of the runner
+type appSpec struct {
+	DeploymentID    string `json:"deploymentId"`
+	ProjectID       string `json:"projectId"`
+	ArchiveURL      string `json:"archiveUrl,omitempty"`
+	StartCommand    string `json:"startCommand"`
+	HealthCheckPath string `json:"healthCheckPath"`
+	Port            int    `json:"port"`
+}
+
+func (spec appSpec) startCommand() string {
+	if spec.StartCommand == "" {
+		return "npm start"
+	}
+	return spec.StartCommand
+}
+
+func (spec appSpec) healthCheckURL() string {
+	path := spec.HealthCheckPath
+	if path == "" {
+		path = "/"
+	}
+	// the port of a container is only published on the advertised host
+	return "http://" + net.JoinHostPort(config.advertiseHost, strconv.Itoa(spec.Port)) + path
+}
+
+// app is a deployment supervised by the runner, restarted when it crashes or stops
+// answering its health check
+type app struct {
+	spec appSpec
+	dir  string
+
+	mu        sync.Mutex
+	state     string
+	restarts  int
+	lastError string
+	// closed when the app passed its first health check, or failed for good
+	started chan struct{}
+	// closed to stop the app, done is closed once it stopped
+	stop chan struct{}
+	done chan struct{}
+}
+
+type appStatus struct {
+	DeploymentID string `json:"deploymentId"`
+	ProjectID    string `json:"projectId"`
+	State        string `json:"state"`
+	Upstream     string `json:"upstream"`
+	Restarts     int    `json:"restarts"`
+	Error        string `json:"error,omitempty"`
+}
+
+func (a *app) status() appStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return appStatus{
+		DeploymentID: a.spec.DeploymentID,
+		ProjectID:    a.spec.ProjectID,
+		State:        a.state,
+		Upstream:     "http://" + net.JoinHostPort(config.advertiseHost, strconv.Itoa(a.spec.Port)),
+		Restarts:     a.restarts,
+		Error:        a.lastError,
+	}
+}
+
+func (a *app) setState(state string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.state = state
+	if err != nil {
+		a.lastError = err.Error()
+	}
+	if state == appRunning || state == appFailed {
+		select {
+		case <-a.started:
+		default:
+			close(a.started)
+		}
+	}
+}
+
+func (a *app) stopping() bool {
+	select {
+	case <-a.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// log a line of the app to its project
+func (a *app) logf(level string, format string, args ...interface{}) {
+	publishLogs(a.spec.ProjectID, fmt.Sprintf("%s: [deployment %s] %s", level, a.spec.DeploymentID, fmt.Sprintf(format, args...)))
+}
+
+// supervise downloads the app if needed and keeps it running until it is stopped
+func (a *app) supervise(runner runner) {
+	defer close(a.done)
+
+	if _, err := os.Stat(a.dir); os.IsNotExist(err) {
+		a.logf("INFO", "downloading the app")
+		err := downloadApp(a.spec.ArchiveURL, a.dir)
+		if err != nil {
+			a.logf("ERROR", "unable to download the app, %s", err)
+			a.setState(appFailed, err)
+			return
+		}
+	}
+
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := a.run(runner)
+		if a.stopping() {
+			a.setState(appStopped, nil)
+			return
+		}
+
+		// an app which ran for a while is restarted right away, one crashing on start slower and slower
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		a.logf("ERROR", "the app stopped, %s, restarting it in %s", err, backoff)
+		a.mu.Lock()
+		a.restarts++
+		a.mu.Unlock()
+		a.setState(appRestarting, err)
+
+		select {
+		case <-a.stop:
+			a.setState(appStopped, nil)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// run starts the app once and returns when it exited, failed its health checks or was stopped
+func (a *app) run(runner runner) error {
+	cmd := runner.command(a)
+	cmd.Stdout = &logWriter{app: a, level: "INFO"}
+	cmd.Stderr = &logWriter{app: a, level: "ERROR"}
+	// children of the app holding its output open don't block the supervisor
+	cmd.WaitDelay = 5 * time.Second
+
+	a.setState(appStarting, nil)
+	a.logf("INFO", "starting the app with %s on port %d", a.spec.startCommand(), a.spec.Port)
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	healthy := false
+	failures := 0
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-exited:
+			if err == nil {
+				err = errors.New("exited")
+			}
+			return err
+		case <-a.stop:
+			terminate(runner, a, cmd, exited)
+			return nil
+		case <-ticker.C:
+		}
+
+		err := checkHealth(a.spec.healthCheckURL())
+		if err == nil {
+			failures = 0
+			if !healthy {
+				healthy = true
+				a.setState(appRunning, nil)
+				a.logf("INFO", "the app is running")
+				ticker.Reset(config.healthInterval)
+			}
+			continue
+		}
+		if !healthy {
+			// the app is still starting
+			continue
+		}
+		failures++
+		a.logf("WARNING", "health check failed, %s", err)
+		if failures >= config.healthFailures {
+			terminate(runner, a, cmd, exited)
+			return fmt.Errorf("failed %d health checks in a row", failures)
+		}
+	}
+}
+
+// stop the app gracefully, killing it if it is still running after 10 seconds
+func terminate(runner runner, a *app, cmd *exec.Cmd, exited chan error) {
+	runner.terminate(a, cmd, false)
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		runner.terminate(a, cmd, true)
+		<-exited
+	}
+}
+
+var healthClient = &http.Client{
+	Timeout: 5 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// any answer but a server error is healthy, a redirect to a login page too
+func checkHealth(url string) error {
+	response, err := healthClient.Get(url)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 500 {
+		return fmt.Errorf("status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// appTable holds the apps of the runner, persisted in the state file of the work directory
+type appTable struct {
+	runner runner
+	mu     sync.Mutex
+	apps   map[string]*app
+}
+
+func newAppTable(runner runner) *appTable {
+	return &appTable{
+		runner: runner,
+		apps:   map[string]*app{},
+	}
+}
+
+func (table *appTable) get(deploymentID string) *app {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	return table.apps[deploymentID]
+}
+
+func (table *appTable) list() []appStatus {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	statuses := []appStatus{}
+	for _, a := range table.apps {
+		statuses = append(statuses, a.status())
+	}
+	return statuses
+}
+
+// start the app of the deployment, or return the one already started
+func (table *appTable) start(spec appSpec) (*app, error) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if a, found := table.apps[spec.DeploymentID]; found {
+		return a, nil
+	}
+
+	port, err := table.freePort()
+	if err != nil {
+		return nil, err
+	}
+	spec.Port = port
+	a := table.launch(spec)
+	table.save()
+
+	return a, nil
+}
+
+// the caller holds the lock
+func (table *appTable) launch(spec appSpec) *app {
+	a := &app{
+		spec:    spec,
+		dir:     filepath.Join(config.workDir, "apps", spec.DeploymentID),
+		state:   appStarting,
+		started: make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	table.apps[spec.DeploymentID] = a
+	go a.supervise(table.runner)
+
+	return a
+}
+
+// the caller holds the lock
+func (table *appTable) freePort() (int, error) {
+	used := map[int]bool{}
+	for _, a := range table.apps {
+		used[a.spec.Port] = true
+	}
+
+	for port := config.portMin; port <= config.portMax; port++ {
+		if used[port] {
+			continue
+		}
+		// a port taken by another process of the host is skipped too
+		listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err != nil {
+			continue
+		}
+		listener.Close()
+		return port, nil
+	}
+
+	return 0, errNoPort
+}
+
+// wait until the app passed its first health check
+func (table *appTable) waitStarted(a *app, timeout time.Duration) error {
+	select {
+	case <-a.started:
+	case <-time.After(timeout):
+		return errStartTimeout
+	}
+
+	status := a.status()
+	if status.State == appFailed {
+		return errors.New(status.Error)
+	}
+	return nil
+}
+
+// stop the app of the deployment and delete its files
+func (table *appTable) stop(deploymentID string) bool {
+	table.mu.Lock()
+	a, found := table.apps[deploymentID]
+	if found {
+		delete(table.apps, deploymentID)
+		table.save()
+	}
+	table.mu.Unlock()
+	if !found {
+		return false
+	}
+
+	close(a.stop)
+	<-a.done
+	a.logf("INFO", "the app was stopped")
+	err := os.RemoveAll(a.dir)
+	if err != nil {
+		log.Println("ERROR: unable to delete the app of deployment", deploymentID, err)
+	}
+
+	return true
+}
+
+// stop all the apps when the runner shuts down, they are started again from the state file
+func (table *appTable) shutdown() {
+	table.mu.Lock()
+	apps := table.apps
+	table.apps = map[string]*app{}
+	table.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, a := range apps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			close(a.stop)
+			<-a.done
+		}()
+	}
+	wg.Wait()
+}
+
+func statePath() string {
+	return filepath.Join(config.workDir, "apps.json")
+}
+
+// write the specs of the apps to the state file, the caller holds the lock
+func (table *appTable) save() {
+	specs := []appSpec{}
+	for _, a := range table.apps {
+		spec := a.spec
+		// the presigned URL expires, the app is already extracted
+		spec.ArchiveURL = ""
+		specs = append(specs, spec)
+	}
+
+	data, err := json.Marshal(specs)
+	if err == nil {
+		err = os.WriteFile(statePath()+".tmp", data, 0o600)
+	}
+	if err == nil {
+		err = os.Rename(statePath()+".tmp", statePath())
+	}
+	if err != nil {
+		log.Println("ERROR: unable to save the state of the apps", err)
+	}
+}
+
+// start the apps of the state file again, after a restart of the runner
+func (table *appTable) restore() error {
+	data, err := os.ReadFile(statePath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	specs := []appSpec{}
+	err = json.Unmarshal(data, &specs)
+	if err != nil {
+		return err
+	}
+
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _, spec := range specs {
+		log.Println("INFO: restarting the app of deployment", spec.DeploymentID)
+		table.launch(spec)
+	}
+
+	return nil
+}
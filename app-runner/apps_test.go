@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+const testServer = `require("http").createServer((request, response) => {
+	if (request.url === "/crash") process.exit(1)
+	response.end("hello from " + process.env.NODE_ENV)
+}).listen(process.env.PORT)
+`
+
+// TestProcessRunner runs a Node app with npm start through the process runner, as the runner
+// does locally, and restarts it after a crash
+func TestProcessRunner(t *testing.T) {
+	if _, err := exec.LookPath("npm"); err != nil {
+		t.Skip("npm is not installed")
+	}
+
+	archive := buildArchive(t, []tarEntry{
+		{name: "package.json", typeflag: tar.TypeReg, body: `{"name": "app", "scripts": {"start": "node server.js"}}`},
+		{name: "server.js", typeflag: tar.TypeReg, body: testServer},
+	}).Bytes()
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		w.Write(archive)
+	}))
+	defer store.Close()
+
+	defer func(saved runnerConfig) { config = saved }(config)
+	config = runnerConfig{
+		workDir:        t.TempDir(),
+		advertiseHost:  "127.0.0.1",
+		portMin:        41000,
+		portMax:        41999,
+		healthInterval: time.Second,
+		healthFailures: 3,
+	}
+	table := newAppTable(processRunner{})
+
+	a, err := table.start(appSpec{DeploymentID: "42", ProjectID: "7", ArchiveURL: store.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.shutdown()
+	err = table.waitStarted(a, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := a.status().Upstream
+	if body := get(t, upstream+"/"); body != "hello from production" {
+		t.Errorf("GET / = %q", body)
+	}
+
+	// the crashed app is started again
+	http.Get(upstream + "/crash")
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		status := a.status()
+		if status.Restarts == 1 && status.State == appRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the app wasn't restarted, status %+v", status)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if body := get(t, upstream+"/"); body != "hello from production" {
+		t.Errorf("GET / after the restart = %q", body)
+	}
+
+	if !table.stop("42") {
+		t.Fatal("stop() didn't find the app")
+	}
+	if _, err := os.Stat(a.dir); !os.IsNotExist(err) {
+		t.Errorf("the files of the stopped app are still in %s", a.dir)
+	}
+	if _, err := http.Get(upstream + "/"); err == nil {
+		t.Error("the stopped app still answers")
+	}
+}
+
+func get(t *testing.T, url string) string {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var archiveClient = &http.Client{Timeout: 10 * time.Minute}
+
+var errUnsafePath = errors.New("the archive has a path outside of the app")
+
+// download the archive of the app built by the build server and extract it in dir, the
+// app is extracted next to dir first so a half extracted app is never started
+func downloadApp(archiveURL string, dir string) error {
+	response, err := archiveClient.Get(archiveURL)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading the archive failed with status %d", response.StatusCode)
+	}
+
+	partial := dir + ".partial"
+	os.RemoveAll(partial)
+	err = extractArchive(response.Body, partial)
+	if err != nil {
+		os.RemoveAll(partial)
+		return err
+	}
+
+	return os.Rename(partial, dir)
+}
+
+func extractArchive(reader io.Reader, dir string) error {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	// symlinks are created last, so no file of the archive is written through one. Their
+	// directories are created with the files, before any symlink exists
+	symlinks := map[string]string{}
+	archive := tar.NewReader(gzipReader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		name, err := archivePath(header.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			continue
+		}
+		target := filepath.Join(root, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0o755)
+		case tar.TypeReg:
+			err = extractFile(archive, root, target, header.FileInfo().Mode())
+		case tar.TypeSymlink:
+			// node_modules/.bin is made of symlinks, they may only point inside the app
+			linked := filepath.Join(filepath.Dir(name), header.Linkname)
+			if filepath.IsAbs(header.Linkname) || linked == ".." || strings.HasPrefix(linked, "../") {
+				return errUnsafePath
+			}
+			symlinks[target] = header.Linkname
+			err = os.MkdirAll(filepath.Dir(target), 0o755)
+		}
+		// hard links, devices and fifos are not part of a build
+		if err != nil {
+			return err
+		}
+	}
+
+	for target, linkname := range symlinks {
+		err := insideApp(root, filepath.Dir(target))
+		if err != nil {
+			return err
+		}
+		err = os.Symlink(linkname, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a symlink through other symlinks may still resolve outside of the app
+	for target := range symlinks {
+		err := insideApp(root, target)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insideApp checks that the real path of path is the app root or below it
+func insideApp(root, path string) error {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return errUnsafePath
+	}
+
+	return nil
+}
+
+// relative path of an archive entry, which may not leave the app
+func archivePath(name string) (string, error) {
+	for _, element := range strings.Split(filepath.ToSlash(name), "/") {
+		if element == ".." {
+			return "", errUnsafePath
+		}
+	}
+
+	return filepath.Clean(strings.TrimLeft(name, "/")), nil
+}
+
+func extractFile(reader io.Reader, root, target string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), 0o755)
+	if err != nil {
+		return err
+	}
+	err = insideApp(root, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func buildArchive(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	buffer := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buffer)
+	archive := tar.NewWriter(gzipWriter)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Linkname: entry.linkname, Mode: 0o644, Size: int64(len(entry.body))}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0o755
+		}
+		if entry.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		err := archive.WriteHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = archive.Write([]byte(entry.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer
+}
+
+func TestArchivePath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"server.js", "server.js", nil},
+		{"./dist/index.js", "dist/index.js", nil},
+		{"/etc/passwd", "etc/passwd", nil},
+		{"node_modules/a/../b/index.js", "", errUnsafePath},
+		{"../server.js", "", errUnsafePath},
+		{"dist/../../server.js", "", errUnsafePath},
+		{"./", ".", nil},
+		{"a..b/c", "a..b/c", nil},
+	}
+
+	for _, test := range tests {
+		got, err := archivePath(test.name)
+		if got != test.want || err != test.err {
+			t.Errorf("archivePath(%q) = %q, %v, want %q, %v", test.name, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		failed  bool
+		unsafe  bool
+		files   map[string]string
+	}{
+		{
+			"an app with the symlinks of node_modules/.bin",
+			[]tarEntry{
+				{name: "package.json", typeflag: tar.TypeReg, body: "{}"},
+				{name: "node_modules/", typeflag: tar.TypeDir},
+				{name: "node_modules/.bin/tool", typeflag: tar.TypeSymlink, linkname: "../tool/cli.js"},
+				{name: "node_modules/tool/cli.js", typeflag: tar.TypeReg, body: "cli"},
+				{name: "dist/root", typeflag: tar.TypeSymlink, linkname: ".."},
+			},
+			false, false,
+			map[string]string{"package.json": "{}", "node_modules/.bin/tool": "cli", "dist/root/package.json": "{}"},
+		},
+		{
+			"an entry outside of the app",
+			[]tarEntry{{name: "../evil", typeflag: tar.TypeReg, body: "evil"}},
+			true, true,
+			nil,
+		},
+		{
+			"a symlink to an absolute path",
+			[]tarEntry{{name: "passwd", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}},
+			true, true,
+			nil,
+		},
+		{
+			"a symlink to the parent of the app",
+			[]tarEntry{{name: "dist/up", typeflag: tar.TypeSymlink, linkname: "../.."}},
+			true, true,
+			nil,
+		},
+		{
+			// the file is extracted in a directory, which the symlink can't replace
+			"a file written through a symlink of the archive",
+			[]tarEntry{
+				{name: "out", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "out/evil", typeflag: tar.TypeReg, body: "evil"},
+			},
+			true, false,
+			nil,
+		},
+		{
+			"a symlink through symlinks leaving the app",
+			[]tarEntry{
+				{name: "d/l", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "x", typeflag: tar.TypeSymlink, linkname: "d/l/.."},
+			},
+			true, true,
+			nil,
+		},
+		{
+			"a symlink created in a symlink leaving the app",
+			[]tarEntry{
+				{name: "d/l", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "x", typeflag: tar.TypeSymlink, linkname: "d/l/.."},
+				{name: "x/evil", typeflag: tar.TypeSymlink, linkname: "."},
+			},
+			true, false,
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "app")
+		err := extractArchive(buildArchive(t, test.entries), dir)
+		if (err != nil) != test.failed || (test.unsafe && err != errUnsafePath) {
+			t.Errorf("%s: extractArchive() = %v, want failed %v", test.name, err, test.failed)
+		}
+
+		// nothing is ever written next to the app
+		if _, err := os.Lstat(filepath.Join(parent, "evil")); err == nil {
+			t.Errorf("%s: a file was written outside of the app", test.name)
+		}
+		for name, want := range test.files {
+			got, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil || string(got) != want {
+				t.Errorf("%s: %s = %q, %v, want %q", test.name, name, got, err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// longest log line published, the rest of a longer line is cut
+const maxLogLine = 16 << 10
+
+var redisClient *redis.Client
+
+// publish a log line of the app to the log channel of its project, like the build server
+// does for the build logs
+func publishLogs(projectID string, line string) {
+	if redisClient == nil {
+		return
+	}
+
+	err := redisClient.Publish(context.Background(), fmt.Sprintf("logs:%s", projectID), line).Err()
+	if err != nil {
+		log.Println("ERROR: unable to publish the logs of project", projectID, err)
+	}
+}
+
+// logWriter publishes the output of an app line by line, level is INFO for stdout and
+// ERROR for stderr
+type logWriter struct {
+	app     *app
+	level   string
+	partial []byte
+}
+
+func (writer *logWriter) Write(data []byte) (int, error) {
+	written := len(data)
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			writer.partial = append(writer.partial, data...)
+			if len(writer.partial) >= maxLogLine {
+				writer.flush()
+			}
+			break
+		}
+		writer.partial = append(writer.partial, data[:end]...)
+		writer.flush()
+		data = data[end+1:]
+	}
+
+	return written, nil
+}
+
+func (writer *logWriter) flush() {
+	line := bytes.TrimRight(writer.partial, "\r")
+	if len(line) > maxLogLine {
+		line = line[:maxLogLine]
+	}
+	writer.partial = writer.partial[:0]
+	if len(line) == 0 {
+		return
+	}
+	writer.app.logf(writer.level, "%s", line)
+}
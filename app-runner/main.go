@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type runnerConfig struct {
+	address       string
+	runner        string
+	workDir       string
+	advertiseHost string
+	portMin       int
+	portMax       int
+	// an app is restarted after healthFailures failed health checks in a row
+	healthTimeout  time.Duration
+	healthInterval time.Duration
+	healthFailures int
+	dockerImage    string
+	dockerMemory   string
+	dockerCPUs     string
+	redisAddress   string
+}
+
+var (
+	config        runnerConfig
+	apps          *appTable
+	internalToken = os.Getenv("INTERNAL_TOKEN")
+)
+
+var deploymentIDPattern = regexp.MustCompile(`^[0-9]+$`)
+
+func main() {
+	flag.StringVar(&config.address, "address", ":9300", "Port of the app runner API")
+	flag.StringVar(&config.runner, "runner", "process", "How the apps are run, process for processes of the host or docker for containers")
+	flag.StringVar(&config.workDir, "work-dir", "apps", "Directory of the extracted apps and the state of the runner")
+	flag.StringVar(&config.advertiseHost, "advertise-host", "127.0.0.1", "Host the reverse proxies reach the apps at")
+	flag.IntVar(&config.portMin, "port-min", 40000, "First port given to the apps")
+	flag.IntVar(&config.portMax, "port-max", 40999, "Last port given to the apps")
+	flag.DurationVar(&config.healthTimeout, "health-timeout", 60*time.Second, "How long a starting app has to pass its first health check")
+	flag.DurationVar(&config.healthInterval, "health-interval", 10*time.Second, "Interval of the health checks of a running app")
+	flag.IntVar(&config.healthFailures, "health-failures", 3, "Failed health checks in a row which restart an app")
+	flag.StringVar(&config.dockerImage, "docker-image", "node:20", "Image of the containers of -runner docker")
+	flag.StringVar(&config.dockerMemory, "docker-memory", "512m", "Memory limit of the containers of -runner docker")
+	flag.StringVar(&config.dockerCPUs, "docker-cpus", "1", "CPU limit of the containers of -runner docker")
+	flag.StringVar(&config.redisAddress, "redis-address", "", "Redis to publish the logs of the apps to, disabled if empty")
+	flag.Parse()
+
+	var appRunner runner
+	switch config.runner {
+	case "process":
+		appRunner = processRunner{}
+	case "docker":
+		appRunner = containerRunner{image: config.dockerImage, memory: config.dockerMemory, cpus: config.dockerCPUs}
+	default:
+		log.Fatal("ERROR: -runner must be process or docker")
+	}
+	if config.portMin <= 0 || config.portMax < config.portMin || config.portMax > 65535 {
+		log.Fatal("ERROR: invalid -port-min and -port-max")
+	}
+	if internalToken == "" {
+		log.Fatal("ERROR: INTERNAL_TOKEN is not set")
+	}
+
+	if config.redisAddress != "" {
+		redisClient = redis.NewClient(&redis.Options{
+			Addr:     config.redisAddress,
+			Password: os.Getenv("REDIS_PASSWORD"),
+			DB:       0,
+		})
+	}
+
+	err := os.MkdirAll(config.workDir, 0o755)
+	if err != nil {
+		log.Fatal("ERROR: unable to create the work directory ", err)
+	}
+	// the directories of the apps are mounted in their containers, docker only mounts absolute paths
+	config.workDir, err = filepath.Abs(config.workDir)
+	if err != nil {
+		log.Fatal("ERROR: unable to resolve the work directory ", err)
+	}
+	apps = newAppTable(appRunner)
+	err = apps.restore()
+	if err != nil {
+		log.Fatal("ERROR: unable to restore the apps ", err)
+	}
+
+	// the apps are stopped with the runner, and started again on its next start
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("INFO: stopping the apps")
+		apps.shutdown()
+		os.Exit(0)
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, request *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	mux.HandleFunc("GET /apps", withInternalToken(listAppsHandler))
+	mux.HandleFunc("GET /apps/{id}", withInternalToken(appHandler))
+	mux.HandleFunc("PUT /apps/{id}", withInternalToken(startAppHandler))
+	mux.HandleFunc("DELETE /apps/{id}", withInternalToken(stopAppHandler))
+
+	// starting the server
+	err = http.ListenAndServe(config.address, mux)
+	if err != nil {
+		log.Fatal("ERROR: unable to start the server", err)
+	}
+}
+
+// the api server and the reverse proxies call the runner with the internal token
+func withInternalToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+		token := request.Header.Get("X-Internal-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(internalToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid internal token")
+			return
+		}
+		handler(w, request)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func listAppsHandler(w http.ResponseWriter, request *http.Request) {
+	writeJSON(w, http.StatusOK, apps.list())
+}
+
+func appHandler(w http.ResponseWriter, request *http.Request) {
+	a := apps.get(request.PathValue("id"))
+	if a == nil {
+		writeError(w, http.StatusNotFound, "no app for this deployment")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.status())
+}
+
+// start the app of the deployment and answer once it passed its health check
+func startAppHandler(w http.ResponseWriter, request *http.Request) {
+	deploymentID := request.PathValue("id")
+	if !deploymentIDPattern.MatchString(deploymentID) {
+		writeError(w, http.StatusBadRequest, "invalid deployment id")
+		return
+	}
+
+	spec := appSpec{}
+	err := json.NewDecoder(request.Body).Decode(&spec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	spec.DeploymentID = deploymentID
+	if spec.ProjectID == "" || spec.ArchiveURL == "" {
+		writeError(w, http.StatusBadRequest, "projectId and archiveUrl are required")
+		return
+	}
+	if spec.HealthCheckPath != "" && spec.HealthCheckPath[0] != '/' {
+		writeError(w, http.StatusBadRequest, "healthCheckPath must start with /")
+		return
+	}
+
+	a, err := apps.start(spec)
+	if err != nil {
+		log.Println("ERROR: unable to start the app of deployment", deploymentID, err)
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	err = apps.waitStarted(a, config.healthTimeout)
+	if err != nil {
+		// the next attempt starts from scratch
+		a.logf("ERROR", "the app did not start, %s", err)
+		apps.stop(deploymentID)
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.status())
+}
+
+func stopAppHandler(w http.ResponseWriter, request *http.Request) {
+	if !apps.stop(request.PathValue("id")) {
+		writeError(w, http.StatusNotFound, "no app for this deployment")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"response": "stopped"})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// runner starts the server of an app, as a process of the host or in a container
+type runner interface {
+	command(a *app) *exec.Cmd
+	// terminate asks the app to stop, force kills it
+	terminate(a *app, cmd *exec.Cmd, force bool)
+}
+
+// appEnv is all an app gets from the runner, never the secrets of the runner itself
+func appEnv(a *app, home string) []string {
+	return []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + home,
+		"NODE_ENV=production",
+		"PORT=" + strconv.Itoa(a.spec.Port),
+	}
+}
+
+// processRunner runs the app as a process group of the host, for local testing
+type processRunner struct{}
+
+func (processRunner) command(a *app) *exec.Cmd {
+	cmd := exec.Command("/bin/sh", "-c", a.spec.startCommand())
+	cmd.Dir = a.dir
+	cmd.Env = appEnv(a, a.dir)
+	// npm start runs node as a child, the whole group is stopped together
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+func (processRunner) terminate(a *app, cmd *exec.Cmd, force bool) {
+	if cmd.Process == nil {
+		return
+	}
+	signal := syscall.SIGTERM
+	if force {
+		signal = syscall.SIGKILL
+	}
+	syscall.Kill(-cmd.Process.Pid, signal)
+}
+
+// containerRunner runs the app in a docker container of image, with the app mounted at /app, its
+// port only published on the advertised host and its memory and CPUs limited
+type containerRunner struct {
+	image  string
+	memory string
+	cpus   string
+}
+
+func containerName(a *app) string {
+	return "scale-mesh-app-" + a.spec.DeploymentID
+}
+
+func (runner containerRunner) command(a *app) *exec.Cmd {
+	// a container left behind by a crash of the runner holds the name and the port
+	exec.Command("docker", "rm", "-f", containerName(a)).Run()
+
+	port := strconv.Itoa(a.spec.Port)
+	args := []string{"run", "--rm", "--init", "--name", containerName(a),
+		"-p", net.JoinHostPort(config.advertiseHost, port) + ":" + port,
+		"--memory", runner.memory, "--cpus", runner.cpus,
+		"-v", a.dir + ":/app", "-w", "/app",
+	}
+	for _, env := range appEnv(a, "/app")[1:] {
+		args = append(args, "-e", env)
+	}
+	args = append(args, runner.image, "/bin/sh", "-c", a.spec.startCommand())
+
+	return exec.Command("docker", args...)
+}
+
+func (runner containerRunner) terminate(a *app, cmd *exec.Cmd, force bool) {
+	if force {
+		exec.Command("docker", "rm", "-f", containerName(a)).Run()
+		return
+	}
+	exec.Command("docker", "stop", "--time", "10", containerName(a)).Run()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// runtime of an app project, its build runs as a server on the app runner instead of being served as files
+const runtimeApp = "app"
+
+func isApp() bool {
+	return os.Getenv("RUNTIME") == runtimeApp
+}
+
+// archive of an app deployment, outside of the public __output prefix
+func appArchiveKey(deploymentID string) string {
+	return "__apps/" + deploymentID + ".tar.gz"
+}
+
+// packApp writes the built app with its dependencies as a tar.gz, the git history is left out.
+// Only the total size is bounded, node_modules easily has more files than a static site may
+func packApp(root string, archive io.Writer, limits outputLimits) (*Manifest, error) {
+	manifest := &Manifest{ProjectID: projectID, DeploymentID: deploymentID}
+
+	gzipWriter := gzip.NewWriter(archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err := filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(root, path)
+		if err != nil || relative == "." {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		// node_modules/.bin holds symlinks, they are kept as links
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relative)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		err = tarWriter.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		manifest.TotalFiles++
+		manifest.TotalSize += info.Size()
+		if manifest.TotalSize > limits.maxTotalSize {
+			return fmt.Errorf("maximum total output size exceeded, the app is larger than %s", formatBytes(limits.maxTotalSize))
+		}
+		if limits.remainingQuota > 0 && manifest.TotalSize > limits.remainingQuota {
+			return fmt.Errorf("storage quota exceeded, the app is larger than the %s left in the plan", formatBytes(limits.remainingQuota))
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+	return manifest, gzipWriter.Close()
+}
+
+// deployApp packs the built app and uploads it for the app runner, nothing of an app is public.
+// The app runner starts it once the deployment is reported ready
+func deployApp(s3Client *s3.Client, root string) {
+	publishLogs(createInfoLogs("Packing the app for the app runner..."))
+
+	archive, err := os.CreateTemp("", "app-*.tar.gz")
+	if err != nil {
+		exitWithError("unable to create the app archive", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	manifest, err := packApp(root, archive, loadOutputLimits())
+	if err != nil {
+		exitWithError("unable to pack the app", err)
+	}
+	info, err := archive.Stat()
+	if err != nil {
+		exitWithError("unable to pack the app", err)
+	}
+	manifest.StoredSize = info.Size()
+	publishLogs(createInfoLogs(fmt.Sprintf("App contains %d files, %d bytes, %d bytes packed.", manifest.TotalFiles, manifest.TotalSize, manifest.StoredSize)))
+
+	_, err = archive.Seek(0, io.SeekStart)
+	if err != nil {
+		exitWithError("unable to upload the app", err)
+	}
+	_, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:        aws.String(bucketName),
+		Body:          archive,
+		Key:           aws.String(appArchiveKey(deploymentID)),
+		ContentType:   aws.String("application/gzip"),
+		ContentLength: aws.Int64(manifest.StoredSize),
+	})
+	if err != nil {
+		exitWithError("unable to upload the app", err)
+	}
+
+	publishLogs(createInfoLogs("App is uploaded, the app runner starts it once it is ready."))
+
+	err = reportStatus(statusReady, "", manifest)
+	if err != nil {
+		log.Println("ERROR: unable to report the deployment status", err)
+		publishLogs(createErrorLogs("unable to report the deployment status", err))
+	}
+}
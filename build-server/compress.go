@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// content types worth compressing, images, fonts and media are already compressed
+var compressibleTypes = map[string]bool{
+	"text/html":                 true,
+	"text/css":                  true,
+	"text/plain":                true,
+	"text/xml":                  true,
+	"text/javascript":           true,
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/xml":           true,
+	"application/wasm":          true,
+	"application/manifest+json": true,
+	"image/svg+xml":             true,
+	"font/ttf":                  true,
+	"font/otf":                  true,
+}
+
+// file extension of the pre-compressed sibling of each encoding
+var encodingExtensions = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+func isCompressible(contentType string, size int64, minSize int64) bool {
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	return size >= minSize && compressibleTypes[mediaType]
+}
+
+// compress the file with every encoding, only variants which are actually smaller are returned
+func compressFile(path string) (map[string][]byte, error) {
+	content, err := os.ReadFile(filepath.FromSlash(path))
+	if err != nil {
+		return nil, err
+	}
+
+	variants := map[string][]byte{}
+
+	var gzipped bytes.Buffer
+	gzipWriter, err := gzip.NewWriterLevel(&gzipped, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = gzipWriter.Write(content); err != nil {
+		return nil, err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	if gzipped.Len() < len(content) {
+		variants["gzip"] = gzipped.Bytes()
+	}
+
+	var brotlied bytes.Buffer
+	brotliWriter := brotli.NewWriterLevel(&brotlied, brotli.BestCompression)
+	if _, err = brotliWriter.Write(content); err != nil {
+		return nil, err
+	}
+	if err = brotliWriter.Close(); err != nil {
+		return nil, err
+	}
+	if brotlied.Len() < len(content) {
+		variants["br"] = brotlied.Bytes()
+	}
+
+	return variants, nil
+}
+
+// compressVariants writes the .gz and .br siblings of the compressible files into dir and records their
+// size in the manifest. The variants count against the storage quota, which is checked before any upload
+func compressVariants(manifest *Manifest, dir string, minSize int64, limits outputLimits) error {
+	existing := map[string]bool{}
+	for _, file := range manifest.Files {
+		existing[file.Path] = true
+	}
+
+	for i := range manifest.Files {
+		file := &manifest.Files[i]
+		if !isCompressible(file.ContentType, file.Size, minSize) {
+			continue
+		}
+
+		variants, err := compressFile(file.Path)
+		if err != nil {
+			return err
+		}
+
+		for encoding, content := range variants {
+			variantPath := file.Path + encodingExtensions[encoding]
+			// never overwrite a file the build produced itself
+			if existing[variantPath] {
+				continue
+			}
+
+			target := filepath.Join(dir, filepath.FromSlash(variantPath))
+			err = os.MkdirAll(filepath.Dir(target), 0o755)
+			if err != nil {
+				return err
+			}
+			err = os.WriteFile(target, content, 0o644)
+			if err != nil {
+				return err
+			}
+
+			if file.Encodings == nil {
+				file.Encodings = map[string]int64{}
+			}
+			file.Encodings[encoding] = int64(len(content))
+			manifest.StoredSize += int64(len(content))
+		}
+
+		err = limits.checkStored(manifest.StoredSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// upload the variants written by compressVariants, the manifest records the size of every variant so
+// the reverse proxy knows which encodings it can serve
+func uploadCompressedVariants(s3Client *s3.Client, manifest *Manifest, deploymentID string, dir string) error {
+	for _, file := range manifest.Files {
+		for encoding, size := range file.Encodings {
+			variantPath := file.Path + encodingExtensions[encoding]
+			content, err := os.Open(filepath.Join(dir, filepath.FromSlash(variantPath)))
+			if err != nil {
+				return err
+			}
+
+			_, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+				Bucket:          aws.String(bucketName),
+				Body:            content,
+				ContentLength:   aws.Int64(size),
+				Key:             aws.String("__output/" + deploymentID + "/" + variantPath),
+				ContentType:     aws.String(file.ContentType),
+				ContentEncoding: aws.String(encoding),
+			})
+			content.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -5,9 +5,10 @@
 
 export GITHUB_REPO_URL=$GITHUB_REPO_URL
 export projectID=$projectID
+export deploymentID=$deploymentID
 
 # 1. Clone the repo
 git clone $GITHUB_REPO_URL /app/output
 
 # 3. execute the build-server script
-/app/bin/build-server
+exec /app/bin/build-server
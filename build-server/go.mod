@@ -3,10 +3,13 @@ module gitlab.com/harisheoran/scale-mesh/build-server
 go 1.23.1
 
 require (
+	github.com/andybalholm/brotli v1.2.6
 	github.com/aws/aws-sdk-go-v2 v1.31.0
 	github.com/aws/aws-sdk-go-v2/config v1.27.39
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.63.3
-	github.com/go-redis/redis v6.15.9+incompatible
+	github.com/joho/godotenv v1.5.1
+	github.com/redis/go-redis/v9 v9.6.1
+	gitlab.com/harisheoran/scale-mesh/siterules v0.0.0
 )
 
 require (
@@ -26,11 +29,7 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/sts v1.31.3 // indirect
 	github.com/aws/smithy-go v1.21.0 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
-	github.com/confluentinc/confluent-kafka-go v1.9.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/onsi/ginkgo v1.16.5 // indirect
-	github.com/onsi/gomega v1.34.2 // indirect
-	github.com/redis/go-redis/v9 v9.6.1 // indirect
-	gopkg.in/confluentinc/confluent-kafka-go.v1 v1.8.2 // indirect
 )
+
+replace gitlab.com/harisheoran/scale-mesh/siterules => ../siterules
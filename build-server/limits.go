@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// outputLimits bound the build output before anything is uploaded
+type outputLimits struct {
+	maxFiles     int
+	maxFileSize  int64
+	maxTotalSize int64
+	// storage left in the plan quota of the user, 0 when the api server didn't send one
+	remainingQuota int64
+}
+
+func loadOutputLimits() outputLimits {
+	return outputLimits{
+		maxFiles:       int(envInt64("MAX_OUTPUT_FILES", 10000)),
+		maxFileSize:    envInt64("MAX_FILE_SIZE", 25<<20),
+		maxTotalSize:   envInt64("MAX_OUTPUT_SIZE", 500<<20),
+		remainingQuota: envInt64("STORAGE_QUOTA_REMAINING", 0),
+	}
+}
+
+// check a file added to the output, totals already include the file
+func (limits outputLimits) check(path string, size int64, totalFiles int, totalSize int64) error {
+	if totalFiles > limits.maxFiles {
+		return fmt.Errorf("maximum file count exceeded, the build output has more than %d files", limits.maxFiles)
+	}
+	if size > limits.maxFileSize {
+		return fmt.Errorf("maximum file size exceeded, %s is %s, the limit is %s", path, formatBytes(size), formatBytes(limits.maxFileSize))
+	}
+	if totalSize > limits.maxTotalSize {
+		return fmt.Errorf("maximum total output size exceeded, the build output is larger than %s", formatBytes(limits.maxTotalSize))
+	}
+	if limits.remainingQuota > 0 && totalSize > limits.remainingQuota {
+		return fmt.Errorf("storage quota exceeded, the build output is larger than the %s left in the plan", formatBytes(limits.remainingQuota))
+	}
+
+	return nil
+}
+
+// check the bytes stored for the output, including the compressed variants of its files
+func (limits outputLimits) checkStored(storedSize int64) error {
+	if limits.remainingQuota > 0 && storedSize > limits.remainingQuota {
+		return fmt.Errorf("storage quota exceeded, the build output and its compressed variants are larger than the %s left in the plan", formatBytes(limits.remainingQuota))
+	}
+
+	return nil
+}
+
+func envInt64(key string, fallback int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
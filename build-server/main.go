@@ -3,12 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
-	"io/fs"
 	"log"
-	"mime"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -26,8 +25,12 @@ var redisClient = redis.NewClient(&redis.Options{
 
 var ctx = context.Background()
 
+var bucketName = "scale-mesh-s3"
+
 var projectID = os.Getenv("projectID")
 
+var deploymentID = os.Getenv("deploymentID")
+
 func main() {
 
 	err := godotenv.Load()
@@ -44,12 +47,12 @@ func main() {
 	// Get the project ID
 	fmt.Println("Project ID", projectID)
 	publishLogs(fmt.Sprintf("Project ID %s", projectID))
+	publishLogs(fmt.Sprintf("Deployment ID %s", deploymentID))
 
 	// Authenticate with AWS SDK
 	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("ap-south-1"))
 	if err != nil {
-		publishLogs(createErrorLogs("unable to auth with AWS", err))
-		log.Fatal("ERROR: unable to auth with AWS", err)
+		exitWithError("unable to auth with AWS", err)
 	}
 
 	// Create a S3 client
@@ -63,23 +66,32 @@ func main() {
 	path := "/app/output"
 	err = os.Chdir(path)
 	if err != nil {
-		publishLogs(createErrorLogs("unable to find the cloned repository, repo is not cloned", err))
-		log.Fatal("ERROR: unable to find cloned repo", err)
+		exitWithError("unable to find the cloned repository, repo is not cloned", err)
 	}
 
 	// build the application
 	log.Println("Building the application ...")
 	publishLogs(createInfoLogs("Building the application ..."))
-	cmd := exec.Command("/bin/sh", "-c", "npm install && npm run build")
+	buildCommand := "npm install && npm run build"
+	if isApp() {
+		// a server may have nothing to build
+		buildCommand = "npm install && npm run build --if-present"
+	}
+	cmd := exec.Command("/bin/sh", "-c", buildCommand)
+	cmd.Env = buildCommandEnv()
 
 	_, err = cmd.CombinedOutput()
 	if err != nil {
-		publishLogs(createErrorLogs("unable to build the application.", err))
-		log.Fatal("ERROR: unable to build the application", err)
+		exitWithError("unable to build the application.", err)
 	}
 	log.Println("Build completed...")
 	publishLogs(createInfoLogs("Build completed."))
 
+	if isApp() {
+		deployApp(client, path)
+		return
+	}
+
 	/*
 	   2. Upload the build artifacts to S3 buckets.
 	*/
@@ -90,57 +102,104 @@ func main() {
 	buildOutputPath := "/app/output/dist/"
 	err = os.Chdir(buildOutputPath)
 	if err != nil {
-		publishLogs(createErrorLogs("Build directory not found /app/output/dist", err))
-		log.Fatal("ERROR: Build directory not found", err)
-	}
-
-	// go through file recursively
-	err = filepath.Walk(".",
-		func(path string, info fs.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			// check for directory: we dont want to upload the directory, only the files within
-			if !info.IsDir() {
-				err = uploadArtifactToS3(client, path, projectID)
-				if err != nil {
-					publishLogs(createErrorLogs("unable to upload the build artifacts", err))
-					log.Fatal("ERROR: uploading the file to S3", err)
-				}
-			}
-			return nil
-		},
-	)
+		exitWithError("Build directory not found /app/output/dist", err)
+	}
+
+	// go through file recursively and record every file in the manifest
+	limits := loadOutputLimits()
+	manifest, err := createManifest(".", limits)
+	if err != nil {
+		exitWithError("unable to prepare the build artifacts for upload", err)
+	}
+	publishLogs(createInfoLogs(fmt.Sprintf("Build output contains %d files, %d bytes.", manifest.TotalFiles, manifest.TotalSize)))
+
+	// validate the _redirects and _headers rule files
+	ruleErrs := validateRuleFiles(manifest)
+	for _, err := range ruleErrs {
+		publishLogs(createErrorLogs("invalid rule file", err))
+	}
+	if len(ruleErrs) > 0 {
+		exitWithError("rule files have syntax errors", fmt.Errorf("%d errors in _redirects or _headers", len(ruleErrs)))
+	}
+
+	// scan the build output for secrets before anything is public
+	policy := scanPolicy()
+	if policy != scanPolicyOff {
+		publishLogs(createInfoLogs(fmt.Sprintf("Scanning the build output for secrets, policy: %s", policy)))
+		findings, err := scanArtifacts(manifest)
+		if err != nil {
+			exitWithError("unable to scan the build artifacts", err)
+		}
+
+		for _, finding := range findings {
+			log.Println("secret scan:", finding)
+			publishLogs(createWarningLogs(fmt.Sprintf("secret scan: %s", finding)))
+		}
+
+		if len(findings) > 0 && policy == scanPolicyBlock {
+			exitWithError("secret scan blocked the deployment", fmt.Errorf("%d findings, remove the files from the build output or set the project's secret scan policy to warn", len(findings)))
+		}
+	}
+
+	// pre-compress the text assets so the reverse proxy doesn't compress them on every request,
+	// the variants are kept out of the build output until they are uploaded
+	publishLogs(createInfoLogs("Compressing the compressible files with gzip and brotli..."))
+	variantsDir, err := os.MkdirTemp("", "variants")
+	if err != nil {
+		exitWithError("unable to create the directory of the compressed build artifacts", err)
+	}
+	err = compressVariants(manifest, variantsDir, envInt64("COMPRESS_MIN_SIZE", 1024), limits)
+	if err != nil {
+		exitWithError("unable to compress the build artifacts", err)
+	}
+
+	for _, file := range manifest.Files {
+		err = uploadArtifactToS3(client, file, deploymentID)
+		if err != nil {
+			exitWithError("unable to upload the build artifacts", err)
+		}
+	}
+
+	publishLogs(createInfoLogs("Uploading the gzip and brotli variants of the compressible files..."))
+	err = uploadCompressedVariants(client, manifest, deploymentID, variantsDir)
+	if err != nil {
+		exitWithError("unable to upload the compressed build artifacts", err)
+	}
+	os.RemoveAll(variantsDir)
+
+	// the manifest is uploaded last, its presence marks a complete upload
+	err = uploadManifestToS3(client, manifest, deploymentID)
 	if err != nil {
-		publishLogs(createErrorLogs("unable to upload the build artifacts", err))
-		fmt.Println(err)
+		exitWithError("unable to upload the artifact manifest", err)
 	}
 
 	publishLogs(createInfoLogs("Build artifacts are uploaded successfully."))
+
+	err = reportStatus(statusReady, "", manifest)
+	if err != nil {
+		log.Println("ERROR: unable to report the deployment status", err)
+		publishLogs(createErrorLogs("unable to report the deployment status", err))
+	}
 }
 
 // Upload artifacts to s3 buckets
-func uploadArtifactToS3(s3Client *s3.Client, filename string, projectID string) error {
-	var bucketName = "scale-mesh-s3"
-	var objectKey = "__output/" + projectID + "/" + filename
+func uploadArtifactToS3(s3Client *s3.Client, artifact ManifestFile, deploymentID string) error {
+	var objectKey = "__output/" + deploymentID + "/" + artifact.Path
 
-	file, err := os.Open(filename)
+	file, err := os.Open(filepath.FromSlash(artifact.Path))
 	if err != nil {
 		return err
 	}
 
 	defer file.Close()
 
-	ext := filepath.Ext(filename)
-	contentType := mime.TypeByExtension(ext)
-	log.Printf("Uploading %s with content-type: %s", filename, contentType)
+	log.Printf("Uploading %s with content-type: %s", artifact.Path, artifact.ContentType)
 
 	_, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
 		Bucket:      aws.String(bucketName),
 		Body:        file,
 		Key:         aws.String(objectKey),
-		ContentType: aws.String(contentType),
+		ContentType: aws.String(artifact.ContentType),
 	})
 
 	if err != nil {
@@ -161,10 +220,38 @@ func publishLogs(log string) error {
 	return nil
 }
 
+// publish the error, report the failed deployment and stop the build
+func exitWithError(message string, err error) {
+	publishLogs(createErrorLogs(message, err))
+
+	reportErr := reportStatus(statusFail, fmt.Sprintf("%s, %s", message, err), nil)
+	if reportErr != nil {
+		log.Println("ERROR: unable to report the deployment status", reportErr)
+	}
+
+	log.Fatal("ERROR: ", message, " ", err)
+}
+
 func createErrorLogs(log string, err error) string {
 	return fmt.Sprintf("ERROR: %s, %s", log, err)
 }
 
+func createWarningLogs(log string) string {
+	return fmt.Sprintf("WARNING: %s", log)
+}
+
 func createInfoLogs(log string) string {
 	return fmt.Sprintf("INFO: %s", log)
 }
+
+// environment of the build command, the scripts of the dependencies run with it
+func buildCommandEnv() []string {
+	env := []string{}
+	for _, variable := range os.Environ() {
+		if strings.HasPrefix(variable, "BUILD_TOKEN=") {
+			continue
+		}
+		env = append(env, variable)
+	}
+	return env
+}
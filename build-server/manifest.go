@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Manifest lists every file of a deployment, it is uploaded beside the artifacts
+// as __output/{deploymentID}.manifest.json
+type Manifest struct {
+	ProjectID    string         `json:"projectId"`
+	DeploymentID string         `json:"deploymentId"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	TotalFiles   int            `json:"totalFiles"`
+	TotalSize    int64          `json:"totalSize"`
+	StoredSize   int64          `json:"storedSize"` // total size including the compressed variants
+	Files        []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"contentType"`
+	// size of the pre-compressed siblings by encoding, "gzip" is stored as path.gz and "br" as path.br
+	Encodings map[string]int64 `json:"encodings,omitempty"`
+}
+
+// walk the build output directory and record the path, size, hash and content type of every file,
+// the walk stops as soon as the output exceeds one of the limits
+func createManifest(root string, limits outputLimits) (*Manifest, error) {
+	manifest := &Manifest{
+		ProjectID:    projectID,
+		DeploymentID: deploymentID,
+		CreatedAt:    time.Now().UTC(),
+		Files:        []ManifestFile{},
+	}
+
+	err := filepath.Walk(root,
+		func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// check for directory: we dont want to upload the directory, only the files within
+			if info.IsDir() {
+				return nil
+			}
+
+			relativePath, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+
+			err = limits.check(filepath.ToSlash(relativePath), info.Size(), manifest.TotalFiles+1, manifest.TotalSize+info.Size())
+			if err != nil {
+				return err
+			}
+
+			hash, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+
+			contentType := mime.TypeByExtension(filepath.Ext(path))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+
+			manifest.Files = append(manifest.Files, ManifestFile{
+				Path:        filepath.ToSlash(relativePath),
+				Size:        info.Size(),
+				SHA256:      hash,
+				ContentType: contentType,
+			})
+			manifest.TotalFiles++
+			manifest.TotalSize += info.Size()
+			manifest.StoredSize += info.Size()
+
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func uploadManifestToS3(s3Client *s3.Client, manifest *Manifest, deploymentID string) error {
+	var objectKey = "__output/" + deploymentID + ".manifest.json"
+
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Body:        bytes.NewReader(body),
+		Key:         aws.String(objectKey),
+		ContentType: aws.String("application/json"),
+	})
+
+	return err
+}
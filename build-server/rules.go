@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+
+	"gitlab.com/harisheoran/scale-mesh/siterules"
+)
+
+// The _redirects and _headers files are parsed with the package the reverse proxy applies them with,
+// a deployment with syntax errors in them fails before anything is uploaded.
+
+// validate the rule files in the root of the build output
+func validateRuleFiles(manifest *Manifest) []error {
+	errs := []error{}
+	for _, file := range manifest.Files {
+		if !siterules.Files[file.Path] {
+			continue
+		}
+
+		content, err := os.Open(file.Path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		var fileErrs []error
+		if file.Path == "_redirects" {
+			_, fileErrs = siterules.ParseRedirects(content)
+		} else {
+			_, fileErrs = siterules.ParseHeaders(content)
+		}
+		content.Close()
+		errs = append(errs, fileErrs...)
+	}
+
+	return errs
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// secret scan policies, set per project by the api server
+const (
+	scanPolicyBlock = "block"
+	scanPolicyWarn  = "warn"
+	scanPolicyOff   = "off"
+)
+
+// pathRule flags files which should never be part of a public build output
+type pathRule struct {
+	name string
+	// glob matched against every path segment, a match on a directory flags everything below it
+	pattern string
+}
+
+var defaultPathRules = []pathRule{
+	{name: "environment file", pattern: ".env"},
+	{name: "environment file", pattern: ".env.*"},
+	{name: "git directory", pattern: ".git"},
+	{name: "svn directory", pattern: ".svn"},
+	{name: "mercurial directory", pattern: ".hg"},
+	{name: "npm credentials", pattern: ".npmrc"},
+	{name: "htpasswd file", pattern: ".htpasswd"},
+	{name: "AWS credentials", pattern: ".aws"},
+	{name: "private key file", pattern: "*.pem"},
+	{name: "private key file", pattern: "*.key"},
+	{name: "PKCS#12 keystore", pattern: "*.p12"},
+	{name: "PKCS#12 keystore", pattern: "*.pfx"},
+	{name: "SSH private key", pattern: "id_rsa"},
+	{name: "SSH private key", pattern: "id_dsa"},
+	{name: "SSH private key", pattern: "id_ecdsa"},
+	{name: "SSH private key", pattern: "id_ed25519"},
+	{name: "source map", pattern: "*.map"},
+}
+
+// contentRule flags a line of a text file which contains a secret
+type contentRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+var defaultContentRules = []contentRule{
+	{name: "AWS access key ID", pattern: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "AWS secret access key", pattern: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`)},
+	{name: "PEM private key", pattern: regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY( BLOCK)?-----`)},
+	{name: "GitHub token", pattern: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{name: "Slack token", pattern: regexp.MustCompile(`\bxox[baprs]-[A-Za-z0-9-]{10,}`)},
+	{name: "Stripe secret key", pattern: regexp.MustCompile(`\b[rs]k_live_[0-9a-zA-Z]{24,}\b`)},
+}
+
+// only text files up to this size are scanned for content rules
+const maxScannedFileSize = 5 << 20
+
+// longer lines are scanned in chunks
+const (
+	maxScannedLine   = 1 << 20
+	scanChunkSize    = 1 << 20
+	scanChunkOverlap = 4 << 10
+)
+
+type scanFinding struct {
+	path string
+	line int // 0 for path rules
+	rule string
+}
+
+func (finding scanFinding) String() string {
+	if finding.line > 0 {
+		return fmt.Sprintf("%s in %s:%d", finding.rule, finding.path, finding.line)
+	}
+	return fmt.Sprintf("%s at %s", finding.rule, finding.path)
+}
+
+func scanPolicy() string {
+	switch policy := os.Getenv("SECRET_SCAN_POLICY"); policy {
+	case scanPolicyWarn, scanPolicyOff:
+		return policy
+	default:
+		return scanPolicyBlock
+	}
+}
+
+// scan every file of the manifest, findings are returned without the matched secret
+func scanArtifacts(manifest *Manifest) ([]scanFinding, error) {
+	findings := []scanFinding{}
+	for _, file := range manifest.Files {
+		if rule, ok := matchPathRule(file.Path); ok {
+			findings = append(findings, scanFinding{path: file.Path, rule: rule})
+		}
+
+		if file.Size > maxScannedFileSize || !isTextContent(file.ContentType) {
+			continue
+		}
+
+		contentFindings, err := scanContent(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, contentFindings...)
+	}
+
+	return findings, nil
+}
+
+func matchPathRule(filePath string) (string, bool) {
+	for _, segment := range strings.Split(filePath, "/") {
+		for _, rule := range defaultPathRules {
+			if matched, _ := path.Match(rule.pattern, segment); matched {
+				return rule.name, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func isTextContent(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType] || mediaType == "application/octet-stream"
+}
+
+func scanContent(filePath string) ([]scanFinding, error) {
+	file, err := os.Open(filepath.FromSlash(filePath))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	findings := []scanFinding{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxScannedLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		for _, rule := range defaultContentRules {
+			if rule.pattern.Match(scanner.Bytes()) {
+				findings = append(findings, scanFinding{path: filePath, line: line, rule: rule.name})
+			}
+		}
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		// minified bundles put everything on a single line
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		return scanChunks(file, filePath)
+	} else if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return findings, nil
+}
+
+// scanChunks scans a file whose lines are too long for the line scanner, in chunks overlapping by
+// more than any secret is long, the line of a finding is counted from the newlines before it
+func scanChunks(reader io.Reader, filePath string) ([]scanFinding, error) {
+	findings := []scanFinding{}
+	found := map[scanFinding]bool{}
+	buffer := make([]byte, 0, scanChunkSize+scanChunkOverlap)
+	// newlines before the start of the buffer
+	lines := 0
+	for {
+		read, err := io.ReadFull(reader, buffer[len(buffer):cap(buffer)])
+		buffer = buffer[:len(buffer)+read]
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+
+		for _, rule := range defaultContentRules {
+			for _, match := range rule.pattern.FindAllIndex(buffer, -1) {
+				finding := scanFinding{path: filePath, line: lines + bytes.Count(buffer[:match[0]], []byte("\n")) + 1, rule: rule.name}
+				// a secret in the overlap is matched by both chunks
+				if !found[finding] {
+					found[finding] = true
+					findings = append(findings, finding)
+				}
+			}
+		}
+
+		if err != nil {
+			return findings, nil
+		}
+		// keep the end of the chunk, a secret may go on in the next one
+		kept := len(buffer) - scanChunkOverlap
+		lines += bytes.Count(buffer[:kept], []byte("\n"))
+		buffer = buffer[:copy(buffer, buffer[kept:])]
+	}
+}
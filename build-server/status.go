@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// deployment status names understood by the api server
+const (
+	statusReady = "READY"
+	statusFail  = "FAIL"
+)
+
+var apiURL = os.Getenv("API_URL")
+
+// buildToken only reports the status of this deployment, it is kept from the build command
+var buildToken = os.Getenv("BUILD_TOKEN")
+
+var apiClient = &http.Client{Timeout: 10 * time.Second}
+
+// report the result of the build to the api server, a ready deployment goes live
+func reportStatus(status string, message string, manifest *Manifest) error {
+	if apiURL == "" {
+		return fmt.Errorf("API_URL is not set")
+	}
+
+	payload := map[string]interface{}{
+		"status":  status,
+		"message": message,
+	}
+	if manifest != nil {
+		payload["totalFiles"] = manifest.TotalFiles
+		payload["totalSize"] = manifest.TotalSize
+		payload["storedSize"] = manifest.StoredSize
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/internal/deployments/%s/status", apiURL, deploymentID)
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Build-Token", buildToken)
+
+	response, err := apiClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("api server responded with %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// access protection of a site, set by the api server on the route
+type siteAccess struct {
+	Mode         string `json:"mode"` // basic, password or team
+	Username     string `json:"username"`
+	PasswordHash string `json:"passwordHash"`
+	Version      string `json:"version"` // changes with the mode and the password, older sessions are dropped
+}
+
+const (
+	accessCookie     = "__scale_mesh_access"
+	accessSessionTTL = 24 * time.Hour
+	// paths of the proxy itself on protected sites
+	accessPathPrefix = "/__scale-mesh/"
+)
+
+// key of the access sessions and of the tokens issued by the api server, the ACCESS_SIGNING_KEY
+// only the api server and the proxies hold
+var accessKey []byte
+
+// accessClaims of a session cookie, or of a token the api server issues to a team member
+type accessClaims struct {
+	ProjectID uint   `json:"p"`
+	Mode      string `json:"m"`
+	Version   string `json:"v"`
+	UserID    uint   `json:"u,omitempty"`
+	ExpiresAt int64  `json:"e"`
+}
+
+var errInvalidToken = errors.New("invalid access token")
+
+func signAccessToken(claims accessClaims) string {
+	payload, _ := json.Marshal(claims)
+	mac := hmac.New(sha256.New, accessKey)
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyAccessToken checks the signature and the expiry of the token, and that it was issued
+// for the current access settings of the project
+func verifyAccessToken(token string, projectRoute *route) (accessClaims, error) {
+	claims := accessClaims{}
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return claims, errInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return claims, errInvalidToken
+	}
+
+	mac := hmac.New(sha256.New, accessKey)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return claims, errInvalidToken
+	}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return claims, errInvalidToken
+	}
+	if claims.ProjectID != projectRoute.ProjectID || claims.Version != projectRoute.Access.Version || time.Now().Unix() > claims.ExpiresAt {
+		return claims, errInvalidToken
+	}
+
+	return claims, nil
+}
+
+// verified passwords, bcrypt takes tens of milliseconds which basic auth would pay on every request
+var checkedPasswords = struct {
+	sync.Mutex
+	entries map[string]bool
+}{entries: map[string]bool{}}
+
+func passwordMatches(hash, password string) bool {
+	sum := sha256.Sum256([]byte(hash + "\x00" + password))
+	key := hex.EncodeToString(sum[:])
+
+	checkedPasswords.Lock()
+	matched := checkedPasswords.entries[key]
+	checkedPasswords.Unlock()
+	if matched {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+
+	checkedPasswords.Lock()
+	if len(checkedPasswords.entries) >= 1000 {
+		checkedPasswords.entries = map[string]bool{}
+	}
+	checkedPasswords.entries[key] = true
+	checkedPasswords.Unlock()
+
+	return true
+}
+
+// checkAccess lets the request through to a protected site, or answers it with the login
+// the access mode asks for. It returns false when the request was answered
+func checkAccess(w http.ResponseWriter, request *http.Request, projectRoute *route) bool {
+	access := projectRoute.Access
+	if access == nil {
+		return true
+	}
+	// without the key no session can be trusted, the site fails closed
+	if len(accessKey) == 0 && access.Mode != "basic" {
+		renderPage(w, http.StatusServiceUnavailable, "Site unavailable", "This site is protected and can't be opened right now.")
+		return false
+	}
+
+	if strings.HasPrefix(request.URL.Path, accessPathPrefix) {
+		serveAccessEndpoint(w, request, projectRoute)
+		return false
+	}
+
+	switch access.Mode {
+	case "basic":
+		username, password, ok := request.BasicAuth()
+		if ok && subtle.ConstantTimeCompare([]byte(username), []byte(access.Username)) == 1 && passwordMatches(access.PasswordHash, password) {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+projectRoute.Slug+`", charset="UTF-8"`)
+		renderPage(w, http.StatusUnauthorized, "Authentication required", "This site is protected, sign in with its user name and password.")
+	case "password":
+		if validSession(request, projectRoute) {
+			return true
+		}
+		loginPage(w, request.URL.RequestURI(), "This site is protected, enter its password to continue.")
+	case "team":
+		if validSession(request, projectRoute) {
+			return true
+		}
+		// RequestURI is the path the visitor asked for, with the /{label} of the path-based routing
+		authorize := config.apiPublicURL + "/access/authorize?" + url.Values{
+			"project":  {strconv.FormatUint(uint64(projectRoute.ProjectID), 10)},
+			"redirect": {requestScheme(request) + "://" + requestHost(request) + request.RequestURI},
+		}.Encode()
+		http.Redirect(w, request, authorize, http.StatusFound)
+	default:
+		// fail closed on a mode this proxy doesn't know
+		renderPage(w, http.StatusForbidden, "Access denied", "This site is not available.")
+	}
+
+	return false
+}
+
+// stripAccessCredentials keeps the access session and the basic auth credentials of the site
+// from the origin a request is proxied to, they are only meant for this proxy
+func stripAccessCredentials(header http.Header, projectRoute *route) {
+	if projectRoute.Access != nil && projectRoute.Access.Mode == "basic" {
+		header.Del("Authorization")
+	}
+
+	lines := header.Values("Cookie")
+	if len(lines) == 0 {
+		return
+	}
+	kept := []string{}
+	for _, line := range lines {
+		for _, pair := range strings.Split(line, ";") {
+			pair = strings.TrimSpace(pair)
+			name, _, _ := strings.Cut(pair, "=")
+			if pair == "" || name == accessCookie {
+				continue
+			}
+			kept = append(kept, pair)
+		}
+	}
+	header.Del("Cookie")
+	if len(kept) > 0 {
+		header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+func validSession(request *http.Request, projectRoute *route) bool {
+	cookie, err := request.Cookie(accessCookie)
+	if err != nil {
+		return false
+	}
+
+	claims, err := verifyAccessToken(cookie.Value, projectRoute)
+	return err == nil && claims.Mode == projectRoute.Access.Mode
+}
+
+// serveAccessEndpoint answers the login form, the team callback and the logout of a protected site
+func serveAccessEndpoint(w http.ResponseWriter, request *http.Request, projectRoute *route) {
+	access := projectRoute.Access
+
+	switch request.URL.Path {
+	case accessPathPrefix + "login":
+		if access.Mode != "password" || request.Method != http.MethodPost {
+			http.NotFound(w, request)
+			return
+		}
+		redirect := localRedirect(request.PostFormValue("redirect"))
+		if !passwordMatches(access.PasswordHash, request.PostFormValue("password")) {
+			loginPage(w, redirect, "Wrong password, try again.")
+			return
+		}
+		startSession(w, request, projectRoute, 0)
+		http.Redirect(w, request, redirect, http.StatusSeeOther)
+	case accessPathPrefix + "callback":
+		if access.Mode != "team" {
+			http.NotFound(w, request)
+			return
+		}
+		claims, err := verifyAccessToken(request.URL.Query().Get("token"), projectRoute)
+		if err != nil || claims.Mode != "team" {
+			renderPage(w, http.StatusForbidden, "Access denied", "The sign in link is invalid or expired, open the site again.")
+			return
+		}
+		startSession(w, request, projectRoute, claims.UserID)
+		http.Redirect(w, request, localRedirect(request.URL.Query().Get("redirect")), http.StatusSeeOther)
+	case accessPathPrefix + "logout":
+		http.SetCookie(w, &http.Cookie{Name: accessCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+		http.Redirect(w, request, "/", http.StatusSeeOther)
+	default:
+		http.NotFound(w, request)
+	}
+}
+
+// startSession sets the signed session cookie of the site
+func startSession(w http.ResponseWriter, request *http.Request, projectRoute *route, userID uint) {
+	token := signAccessToken(accessClaims{
+		ProjectID: projectRoute.ProjectID,
+		Mode:      projectRoute.Access.Mode,
+		Version:   projectRoute.Access.Version,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(accessSessionTTL).Unix(),
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(accessSessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   requestScheme(request) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// localRedirect only lets the visitor go back to a path of the same site
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+// privateResponse keeps shared caches from storing the responses of a protected site
+type privateResponse struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *privateResponse) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.Header()
+	if cacheControl := header.Get("Cache-Control"); strings.HasPrefix(cacheControl, "public") {
+		header.Set("Cache-Control", "private"+strings.TrimPrefix(cacheControl, "public"))
+	} else if cacheControl == "" {
+		header.Set("Cache-Control", "private")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *privateResponse) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.ResponseWriter.Write(p)
+}
+
+// ReadFrom keeps the sendfile path of the wrapped writer for artifacts served from the disk cache
+func (w *privateResponse) ReadFrom(src io.Reader) (int64, error) {
+	w.WriteHeader(http.StatusOK)
+	if readerFrom, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return readerFrom.ReadFrom(src)
+	}
+	return io.Copy(w.ResponseWriter, src)
+}
+
+// Unwrap lets http.ResponseController hijack the WebSocket connections of the apps
+func (w *privateResponse) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// structured access log, nil when disabled
+var accessLog *slog.Logger
+
+type requestEntryKey struct{}
+
+// requestEntry is filled in by the handlers once they know the route of the request
+type requestEntry struct {
+	projectID    uint
+	deploymentID uint
+}
+
+// noteRoute records the project and the deployment serving the request for its access log line
+func noteRoute(request *http.Request, projectRoute *route) {
+	if entry, ok := request.Context().Value(requestEntryKey{}).(*requestEntry); ok {
+		entry.projectID = projectRoute.ProjectID
+		entry.deploymentID = projectRoute.DeploymentID
+	}
+}
+
+// loggedResponse records the status and the size of the response
+type loggedResponse struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *loggedResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggedResponse) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the sendfile path of the wrapped writer
+func (w *loggedResponse) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if readerFrom, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = readerFrom.ReadFrom(src)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, src)
+	}
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController flush the proxied responses
+func (w *loggedResponse) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withAccessLog logs every request and counts it in the traffic of its project
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		entry := &requestEntry{}
+		logged := &loggedResponse{ResponseWriter: w}
+
+		next.ServeHTTP(logged, request.WithContext(context.WithValue(request.Context(), requestEntryKey{}, entry)))
+
+		latency := time.Since(start)
+		if logged.status == 0 {
+			logged.status = http.StatusOK
+		}
+
+		if accessLog != nil {
+			accessLog.LogAttrs(request.Context(), slog.LevelInfo, "request",
+				slog.String("host", requestHost(request)),
+				slog.Uint64("project", uint64(entry.projectID)),
+				slog.Uint64("deployment", uint64(entry.deploymentID)),
+				slog.String("method", request.Method),
+				slog.String("path", request.URL.Path),
+				slog.Int("status", logged.status),
+				slog.Int64("bytes", logged.bytes),
+				slog.Float64("latencyMs", float64(latency.Microseconds())/1000),
+				slog.String("referrer", request.Referer()),
+				slog.String("userAgent", request.UserAgent()),
+				slog.String("clientIp", clientIP(request)),
+			)
+		}
+
+		if traffic != nil && entry.projectID != 0 {
+			traffic.record(entry.projectID, start, request.URL.Path, logged.status, logged.bytes, latency)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// distinct paths counted per project and hour between two flushes, the rest is counted as otherPaths
+const (
+	maxTrackedPaths = 200
+	otherPaths      = "(other)"
+)
+
+// trafficStat counts the requests of a project in an hour
+type trafficStat struct {
+	ProjectID uint             `json:"projectId"`
+	Hour      time.Time        `json:"hour"`
+	Requests  int64            `json:"requests"`
+	Bytes     int64            `json:"bytes"`
+	Status2xx int64            `json:"status2xx"`
+	Status3xx int64            `json:"status3xx"`
+	Status4xx int64            `json:"status4xx"`
+	Status5xx int64            `json:"status5xx"`
+	LatencyMs int64            `json:"latencyMs"` // sum over the requests
+	Paths     map[string]int64 `json:"paths"`
+}
+
+func (stat *trafficStat) merge(other *trafficStat) {
+	stat.Requests += other.Requests
+	stat.Bytes += other.Bytes
+	stat.Status2xx += other.Status2xx
+	stat.Status3xx += other.Status3xx
+	stat.Status4xx += other.Status4xx
+	stat.Status5xx += other.Status5xx
+	stat.LatencyMs += other.LatencyMs
+	for path, requests := range other.Paths {
+		stat.countPath(path, requests)
+	}
+}
+
+func (stat *trafficStat) countPath(path string, requests int64) {
+	if _, ok := stat.Paths[path]; !ok && len(stat.Paths) >= maxTrackedPaths {
+		path = otherPaths
+	}
+	stat.Paths[path] += requests
+}
+
+type trafficKey struct {
+	projectID uint
+	hour      time.Time
+}
+
+// trafficCounter aggregates the access log into per project counters,
+// which are sent to the api server on every flush
+type trafficCounter struct {
+	apiURL string
+	token  string
+	client *http.Client
+
+	mu    sync.Mutex
+	stats map[trafficKey]*trafficStat
+}
+
+var traffic *trafficCounter
+
+func newTrafficCounter(apiURL, token string) *trafficCounter {
+	return &trafficCounter{
+		apiURL: apiURL,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+		stats:  map[trafficKey]*trafficStat{},
+	}
+}
+
+func (counter *trafficCounter) record(projectID uint, at time.Time, path string, status int, bytes int64, latency time.Duration) {
+	key := trafficKey{projectID: projectID, hour: at.UTC().Truncate(time.Hour)}
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	stat, ok := counter.stats[key]
+	if !ok {
+		stat = &trafficStat{ProjectID: key.projectID, Hour: key.hour, Paths: map[string]int64{}}
+		counter.stats[key] = stat
+	}
+
+	stat.Requests++
+	stat.Bytes += bytes
+	stat.LatencyMs += latency.Milliseconds()
+	switch status / 100 {
+	case 2:
+		stat.Status2xx++
+	case 3:
+		stat.Status3xx++
+	case 4:
+		stat.Status4xx++
+	case 5:
+		stat.Status5xx++
+	}
+	stat.countPath(path, 1)
+}
+
+// flush sends the counters to the api server, they are kept for the next flush when it fails
+func (counter *trafficCounter) flush(ctx context.Context) error {
+	counter.mu.Lock()
+	pending := counter.stats
+	counter.stats = map[trafficKey]*trafficStat{}
+	counter.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	stats := make([]*trafficStat, 0, len(pending))
+	for _, stat := range pending {
+		stats = append(stats, stat)
+	}
+
+	err := counter.post(ctx, stats)
+	if err != nil {
+		counter.mu.Lock()
+		for key, stat := range pending {
+			if current, ok := counter.stats[key]; ok {
+				stat.merge(current)
+			}
+			counter.stats[key] = stat
+		}
+		counter.mu.Unlock()
+	}
+
+	return err
+}
+
+func (counter *trafficCounter) post(ctx context.Context, stats []*trafficStat) error {
+	payload, err := json.Marshal(map[string]any{"stats": stats})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, counter.apiURL+"/internal/analytics", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Internal-Token", counter.token)
+
+	response, err := counter.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("api server responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+// flushTraffic sends the counters every interval until the context is done
+func (counter *trafficCounter) flushTraffic(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := counter.flush(ctx)
+			if err != nil {
+				log.Println("ERROR: unable to send the traffic analytics", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const runtimeApp = "app"
+
+var errAppNotRunning = errors.New("the app of the deployment is not running")
+
+type cachedApp struct {
+	upstream  *url.URL
+	err       error
+	expiresAt time.Time
+}
+
+// appTable looks up where the app runner runs the app of a deployment and caches it for ttl
+type appTable struct {
+	runnerURL string
+	token     string
+	ttl       time.Duration
+	client    *http.Client
+
+	mu      sync.Mutex
+	entries map[string]cachedApp
+}
+
+var runningApps *appTable
+
+func newAppTable(runnerURL, token string, ttl time.Duration) *appTable {
+	return &appTable{
+		runnerURL: runnerURL,
+		token:     token,
+		ttl:       ttl,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		entries:   map[string]cachedApp{},
+	}
+}
+
+// lookup the upstream of the running app of the deployment
+func (table *appTable) lookup(deploymentID string) (*url.URL, error) {
+	table.mu.Lock()
+	entry, ok := table.entries[deploymentID]
+	table.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.upstream, entry.err
+	}
+
+	upstream, err := table.fetch(deploymentID)
+	if err != nil && err != errAppNotRunning {
+		// keep proxying to the last known upstream while the runner is unreachable
+		if ok && entry.upstream != nil {
+			return entry.upstream, nil
+		}
+		return nil, err
+	}
+
+	table.mu.Lock()
+	table.entries[deploymentID] = cachedApp{upstream: upstream, err: err, expiresAt: time.Now().Add(table.ttl)}
+	table.mu.Unlock()
+
+	return upstream, err
+}
+
+// forget the app of the deployment, after its upstream refused a connection
+func (table *appTable) forget(deploymentID string) {
+	table.mu.Lock()
+	delete(table.entries, deploymentID)
+	table.mu.Unlock()
+}
+
+func (table *appTable) fetch(deploymentID string) (*url.URL, error) {
+	request, err := http.NewRequest(http.MethodGet, table.runnerURL+"/apps/"+url.PathEscape(deploymentID), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("X-Internal-Token", table.token)
+
+	response, err := table.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, errAppNotRunning
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("app runner responded with %s", response.Status)
+	}
+
+	status := struct {
+		State    string `json:"state"`
+		Upstream string `json:"upstream"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&status)
+	if err != nil {
+		return nil, err
+	}
+	// a starting or restarting app is not served until it passes its health check
+	if status.State != "running" {
+		return nil, errAppNotRunning
+	}
+
+	return url.Parse(status.Upstream)
+}
+
+// the apps run on the internal network, unlike the targets of the proxy rules
+var appTransport = &http.Transport{
+	Proxy: nil,
+	DialContext: (&net.Dialer{
+		Timeout: 5 * time.Second,
+	}).DialContext,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 20,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// serveApp proxies the request to the running app of the deployment, WebSocket upgrades included
+func serveApp(w http.ResponseWriter, request *http.Request, projectRoute *route) {
+	deploymentID := projectRoute.deploymentKey()
+	upstream, err := runningApps.lookup(deploymentID)
+	if err == errAppNotRunning {
+		appUnavailable(w)
+		return
+	} else if err != nil {
+		log.Println("ERROR: unable to look up the app of deployment", deploymentID, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(proxyRequest *httputil.ProxyRequest) {
+			proxyRequest.SetURL(upstream)
+			// the app sees the host of the site, and the client behind the trusted proxies
+			proxyRequest.Out.Host = proxyRequest.In.Host
+			proxyRequest.Out.Header.Set("X-Forwarded-For", clientIP(request))
+			proxyRequest.Out.Header.Set("X-Forwarded-Host", requestHost(request))
+			proxyRequest.Out.Header.Set("X-Forwarded-Proto", requestScheme(request))
+			stripAccessCredentials(proxyRequest.Out.Header, projectRoute)
+			dropRangesUnderPrefix(proxyRequest)
+		},
+		Transport:      appTransport,
+		ModifyResponse: preferOriginHeaders(w),
+		ErrorHandler: func(w http.ResponseWriter, proxied *http.Request, err error) {
+			log.Println("ERROR: proxying to the app of deployment", deploymentID, err)
+			if isDialError(err) {
+				// the app is restarting, the runner tells when it is back
+				runningApps.forget(deploymentID)
+				appUnavailable(w)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		},
+	}
+
+	proxy.ServeHTTP(w, request)
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func appUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "5")
+	renderPage(w, http.StatusServiceUnavailable, "Temporarily unavailable", "This app is starting or restarting, please try again in a moment.")
+}
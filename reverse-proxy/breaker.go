@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errStorageUnavailable = errors.New("storage is unavailable, its circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed   circuitState = iota // requests go to the storage
+	circuitOpen                         // requests fail fast until the cooldown is over
+	circuitHalfOpen                     // a single probe decides whether the circuit closes again
+)
+
+// circuitBreaker stops sending requests to a failing storage after threshold failures in a row,
+// once cooldown is over a single request probes whether it recovered
+type circuitBreaker struct {
+	threshold int // disabled if 0
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	opens    atomic.Int64
+	rejected atomic.Int64
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a request may go to the storage, the caller reports its outcome
+// with success, failure or abandon
+func (breaker *circuitBreaker) allow() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch {
+	case breaker.state == circuitClosed:
+		return true
+	case breaker.state == circuitOpen && time.Since(breaker.openedAt) >= breaker.cooldown:
+		breaker.state = circuitHalfOpen
+		breaker.probing = true
+		return true
+	case breaker.state == circuitHalfOpen && !breaker.probing:
+		breaker.probing = true
+		return true
+	}
+
+	breaker.rejected.Add(1)
+	return false
+}
+
+// the storage answered
+func (breaker *circuitBreaker) success() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	breaker.state = circuitClosed
+	breaker.failures = 0
+	breaker.probing = false
+}
+
+// the storage was unreachable or failed to answer
+func (breaker *circuitBreaker) failure() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	breaker.failures++
+	if breaker.threshold <= 0 || (breaker.state == circuitClosed && breaker.failures < breaker.threshold) {
+		return
+	}
+	if breaker.state == circuitClosed {
+		breaker.opens.Add(1)
+	}
+	breaker.state = circuitOpen
+	breaker.openedAt = time.Now()
+	breaker.probing = false
+}
+
+// abandon releases the probe of a request cancelled by its client, which tells nothing about the storage
+func (breaker *circuitBreaker) abandon() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	breaker.probing = false
+}
+
+// degraded reports whether the storage is considered down
+func (breaker *circuitBreaker) degraded() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	return breaker.state != circuitClosed
+}
+
+// retryAfter is the time left until the next probe of the storage
+func (breaker *circuitBreaker) retryAfter() time.Duration {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if breaker.state != circuitOpen {
+		return 0
+	}
+	return max(breaker.cooldown-time.Since(breaker.openedAt), 0)
+}
+
+// writeMetrics writes the state of the breaker in the Prometheus text format
+func (breaker *circuitBreaker) writeMetrics(w http.ResponseWriter) {
+	breaker.mu.Lock()
+	state := breaker.state
+	breaker.mu.Unlock()
+
+	fmt.Fprintln(w, "# TYPE proxy_storage_circuit_state gauge")
+	fmt.Fprintf(w, "proxy_storage_circuit_state %d\n", state)
+	fmt.Fprintln(w, "# TYPE proxy_storage_circuit_opens_total counter")
+	fmt.Fprintf(w, "proxy_storage_circuit_opens_total %d\n", breaker.opens.Load())
+	fmt.Fprintln(w, "# TYPE proxy_storage_rejected_total counter")
+	fmt.Fprintf(w, "proxy_storage_rejected_total %d\n", breaker.rejected.Load())
+}
+
+// storageUnavailable answers a request which needs an object the cache doesn't have while the storage is down
+func storageUnavailable(w http.ResponseWriter) {
+	header := w.Header()
+	// set for the artifact before it was opened
+	for _, name := range []string{"Content-Encoding", "Content-Length", "ETag", "Last-Modified", "Accept-Ranges"} {
+		header.Del(name)
+	}
+	retryAfter := max(int(artifacts.breaker.retryAfter().Seconds()), 1)
+	header.Set("Retry-After", strconv.Itoa(retryAfter))
+	renderPage(w, http.StatusServiceUnavailable, "Temporarily unavailable", "This site can't be served right now, please try again in a moment.")
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// lru keeps entries up to maxBytes, the least recently used entries are evicted first
+type lru struct {
+	maxBytes int64
+	onEvict  func(entry *lruEntry)
+
+	mu        sync.Mutex
+	usedBytes int64
+	order     *list.List // front is the most recently used
+	entries   map[string]*list.Element
+}
+
+type lruEntry struct {
+	key  string
+	size int64
+	data []byte // content of the memory tier, the disk tier keeps it in a file
+}
+
+func newLRU(maxBytes int64, onEvict func(entry *lruEntry)) *lru {
+	return &lru{
+		maxBytes: maxBytes,
+		onEvict:  onEvict,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (cache *lru) get(key string) (*lruEntry, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	cache.order.MoveToFront(element)
+
+	return element.Value.(*lruEntry), true
+}
+
+// add the entry and evict until it fits, returns the number of evicted entries
+func (cache *lru) add(entry *lruEntry) int {
+	if entry.size > cache.maxBytes {
+		return 0
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[entry.key]; ok {
+		cache.usedBytes -= element.Value.(*lruEntry).size
+		cache.order.Remove(element)
+	}
+	cache.entries[entry.key] = cache.order.PushFront(entry)
+	cache.usedBytes += entry.size
+
+	evicted := 0
+	for cache.usedBytes > cache.maxBytes {
+		oldest := cache.order.Back()
+		if oldest == nil {
+			break
+		}
+		cache.removeElement(oldest)
+		evicted++
+	}
+
+	return evicted
+}
+
+func (cache *lru) remove(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		cache.removeElement(element)
+	}
+}
+
+func (cache *lru) removeElement(element *list.Element) {
+	entry := element.Value.(*lruEntry)
+	cache.order.Remove(element)
+	delete(cache.entries, entry.key)
+	cache.usedBytes -= entry.size
+	if cache.onEvict != nil {
+		cache.onEvict(entry)
+	}
+}
+
+func (cache *lru) stats() (entries int, usedBytes int64) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return len(cache.entries), cache.usedBytes
+}
+
+type cacheStats struct {
+	memoryHits      atomic.Int64
+	diskHits        atomic.Int64
+	misses          atomic.Int64
+	memoryEvictions atomic.Int64
+	diskEvictions   atomic.Int64
+	// hits while the storage is down, served from the cache only
+	degradedHits atomic.Int64
+}
+
+// artifactCache keeps artifacts in front of the storage, small files in memory and larger ones on disk.
+// Entries are keyed by the storage key which holds the deployment ID, a deployment is never
+// changed after it is built, so promoting another deployment can't leave a stale entry behind.
+type artifactCache struct {
+	storage *artifactStore
+
+	memory        *lru
+	memoryMaxFile int64
+
+	disk        *lru // nil when the disk tier is disabled
+	dir         string
+	diskMaxFile int64
+
+	stats cacheStats
+}
+
+func newArtifactCache(storage *artifactStore, memorySize, memoryMaxFile int64, dir string, diskSize, diskMaxFile int64) (*artifactCache, error) {
+	cache := &artifactCache{
+		storage:       storage,
+		memory:        newLRU(memorySize, nil),
+		memoryMaxFile: memoryMaxFile,
+		dir:           dir,
+		diskMaxFile:   diskMaxFile,
+	}
+	if dir == "" {
+		return cache, nil
+	}
+
+	cache.disk = newLRU(diskSize, func(entry *lruEntry) {
+		err := os.Remove(filepath.Join(dir, entry.key))
+		if err != nil && !os.IsNotExist(err) {
+			log.Println("ERROR: unable to delete the cached artifact", entry.key, err)
+		}
+	})
+
+	err := cache.loadDisk()
+	if err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+// index the files cached on disk by an earlier run, the oldest modified ones are evicted first
+func (cache *artifactCache) loadDisk() error {
+	err := os.MkdirAll(cache.dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	dirEntries, err := os.ReadDir(cache.dir)
+	if err != nil {
+		return err
+	}
+
+	type cachedFile struct {
+		name string
+		info os.FileInfo
+	}
+	files := []cachedFile{}
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		// leftovers of downloads interrupted by a restart
+		if strings.HasPrefix(name, "tmp-") {
+			os.Remove(filepath.Join(cache.dir, name))
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil || !info.Mode().IsRegular() || len(name) != sha256.Size*2 {
+			continue
+		}
+		files = append(files, cachedFile{name: name, info: info})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	for _, file := range files {
+		cache.disk.add(&lruEntry{key: file.name, size: file.info.Size()})
+	}
+
+	return nil
+}
+
+// diskName is the file name of a storage key in the cache directory
+func diskName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// open the artifact at key from the cache, or from the storage filling the cache while it is read
+func (cache *artifactCache) open(ctx context.Context, key string) (*artifact, error) {
+	if entry, ok := cache.memory.get(key); ok {
+		cache.stats.memoryHits.Add(1)
+		cache.countDegradedHit()
+		return &artifact{body: memoryBody{bytes.NewReader(entry.data)}, size: entry.size, header: http.Header{}}, nil
+	}
+
+	if cache.disk != nil {
+		name := diskName(key)
+		if entry, ok := cache.disk.get(name); ok {
+			file, err := os.Open(filepath.Join(cache.dir, name))
+			if err == nil {
+				cache.stats.diskHits.Add(1)
+				cache.countDegradedHit()
+				return &artifact{body: file, size: entry.size, header: http.Header{}}, nil
+			}
+			cache.disk.remove(name)
+		}
+	}
+
+	cache.stats.misses.Add(1)
+	object, err := cache.storage.open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// only objects of a known size are cached, so a cut off download is never kept
+	switch {
+	case object.size < 0:
+	case object.size <= cache.memoryMaxFile:
+		object.body = &memoryFill{ReadCloser: object.body, cache: cache, key: key, size: object.size}
+	case cache.disk != nil && object.size <= cache.diskMaxFile:
+		temp, err := os.CreateTemp(cache.dir, "tmp-")
+		if err != nil {
+			log.Println("ERROR: unable to create a file in the cache directory", err)
+			break
+		}
+		object.body = &diskFill{ReadCloser: object.body, cache: cache, name: diskName(key), size: object.size, temp: temp}
+	}
+
+	return object, nil
+}
+
+// the cache keeps the sites up while the storage is down, deployments never change so its entries don't go stale
+func (cache *artifactCache) countDegradedHit() {
+	if cache.storage.breaker.degraded() {
+		cache.stats.degradedHits.Add(1)
+	}
+}
+
+// memoryBody reads a file of the memory tier, it can seek to serve ranges
+type memoryBody struct {
+	*bytes.Reader
+}
+
+func (memoryBody) Close() error {
+	return nil
+}
+
+// memoryFill keeps a copy of the object read from the storage, it is cached once read completely
+type memoryFill struct {
+	io.ReadCloser
+	cache  *artifactCache
+	key    string
+	size   int64
+	buffer bytes.Buffer
+}
+
+func (fill *memoryFill) Read(p []byte) (int, error) {
+	n, err := fill.ReadCloser.Read(p)
+	fill.buffer.Write(p[:n])
+	if err == io.EOF && int64(fill.buffer.Len()) == fill.size {
+		evicted := fill.cache.memory.add(&lruEntry{key: fill.key, size: fill.size, data: fill.buffer.Bytes()})
+		fill.cache.stats.memoryEvictions.Add(int64(evicted))
+	}
+
+	return n, err
+}
+
+// diskFill writes the object read from the storage to a temporary file,
+// it is moved into the cache directory once read completely
+type diskFill struct {
+	io.ReadCloser
+	cache   *artifactCache
+	name    string
+	size    int64
+	temp    *os.File
+	written int64
+	failed  bool
+}
+
+func (fill *diskFill) Read(p []byte) (int, error) {
+	n, err := fill.ReadCloser.Read(p)
+	if !fill.failed && n > 0 {
+		_, writeErr := fill.temp.Write(p[:n])
+		if writeErr != nil {
+			log.Println("ERROR: unable to write to the artifact cache", writeErr)
+			fill.failed = true
+		}
+		fill.written += int64(n)
+	}
+	if err == io.EOF && !fill.failed && fill.written == fill.size {
+		fill.commit()
+	}
+
+	return n, err
+}
+
+func (fill *diskFill) commit() {
+	fill.failed = true // the temporary file is gone either way
+	tempName := fill.temp.Name()
+
+	err := fill.temp.Close()
+	if err == nil {
+		err = os.Rename(tempName, filepath.Join(fill.cache.dir, fill.name))
+	}
+	if err != nil {
+		log.Println("ERROR: unable to add the artifact to the disk cache", err)
+		os.Remove(tempName)
+		return
+	}
+
+	evicted := fill.cache.disk.add(&lruEntry{key: fill.name, size: fill.size})
+	fill.cache.stats.diskEvictions.Add(int64(evicted))
+}
+
+func (fill *diskFill) Close() error {
+	if !fill.failed {
+		fill.temp.Close()
+		os.Remove(fill.temp.Name())
+		fill.failed = true
+	}
+
+	return fill.ReadCloser.Close()
+}
+
+// writeMetrics writes the cache counters in the Prometheus text format
+func (cache *artifactCache) writeMetrics(w http.ResponseWriter) {
+	memoryEntries, memoryBytes := cache.memory.stats()
+	diskEntries, diskBytes := 0, int64(0)
+	if cache.disk != nil {
+		diskEntries, diskBytes = cache.disk.stats()
+	}
+
+	fmt.Fprintln(w, "# TYPE proxy_cache_hits_total counter")
+	fmt.Fprintf(w, "proxy_cache_hits_total{tier=\"memory\"} %d\n", cache.stats.memoryHits.Load())
+	fmt.Fprintf(w, "proxy_cache_hits_total{tier=\"disk\"} %d\n", cache.stats.diskHits.Load())
+	fmt.Fprintln(w, "# TYPE proxy_cache_misses_total counter")
+	fmt.Fprintf(w, "proxy_cache_misses_total %d\n", cache.stats.misses.Load())
+	fmt.Fprintln(w, "# TYPE proxy_cache_evictions_total counter")
+	fmt.Fprintf(w, "proxy_cache_evictions_total{tier=\"memory\"} %d\n", cache.stats.memoryEvictions.Load())
+	fmt.Fprintf(w, "proxy_cache_evictions_total{tier=\"disk\"} %d\n", cache.stats.diskEvictions.Load())
+	fmt.Fprintln(w, "# TYPE proxy_cache_degraded_hits_total counter")
+	fmt.Fprintf(w, "proxy_cache_degraded_hits_total %d\n", cache.stats.degradedHits.Load())
+	fmt.Fprintln(w, "# TYPE proxy_cache_entries gauge")
+	fmt.Fprintf(w, "proxy_cache_entries{tier=\"memory\"} %d\n", memoryEntries)
+	fmt.Fprintf(w, "proxy_cache_entries{tier=\"disk\"} %d\n", diskEntries)
+	fmt.Fprintln(w, "# TYPE proxy_cache_bytes gauge")
+	fmt.Fprintf(w, "proxy_cache_bytes{tier=\"memory\"} %d\n", memoryBytes)
+	fmt.Fprintf(w, "proxy_cache_bytes{tier=\"disk\"} %d\n", diskBytes)
+}
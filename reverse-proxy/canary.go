@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// canaryRoute splits the visitors of a project between its live deployment and a canary
+type canaryRoute struct {
+	DeploymentID uint `json:"deploymentId"`
+	Weight       int  `json:"weight"` // percentage of the visitors served by the canary
+	// nil for a public canary, it can differ from the access of the live deployment
+	Access *siteAccess `json:"access"`
+}
+
+const (
+	bucketCookie    = "__scale_mesh_bucket"
+	bucketCookieTTL = 30 * 24 * time.Hour
+)
+
+// visitorBucket is the sticky percentile of the visitor, the buckets below the weight of the canary
+// are served the canary. Raising the weight only moves more visitors to the canary
+func visitorBucket(w http.ResponseWriter, request *http.Request) int {
+	if cookie, err := request.Cookie(bucketCookie); err == nil {
+		bucket, err := strconv.Atoi(cookie.Value)
+		if err == nil && bucket >= 0 && bucket < 100 {
+			return bucket
+		}
+	}
+
+	bucket := rand.IntN(100)
+	http.SetCookie(w, &http.Cookie{
+		Name:     bucketCookie,
+		Value:    strconv.Itoa(bucket),
+		Path:     "/",
+		MaxAge:   int(bucketCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   requestScheme(request) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return bucket
+}
+
+// servedRoute is the route of the deployment serving the visitor, the canary for its share of them
+func servedRoute(w http.ResponseWriter, request *http.Request, projectRoute *route) *route {
+	canary := projectRoute.Canary
+	if canary == nil || canary.DeploymentID == 0 || canary.Weight <= 0 {
+		return projectRoute
+	}
+	if visitorBucket(w, request) >= canary.Weight {
+		return projectRoute
+	}
+
+	served := *projectRoute
+	served.DeploymentID = canary.DeploymentID
+	served.Access = canary.Access
+	return &served
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// content hashes bundlers put in asset names, the hex of webpack like main.3f2a1b9c.js
+// or next-2c79e2a64abdb08b.js and the 8 base64url characters of vite like index-D8nHk3qL.js
+var (
+	hexAssetHash    = regexp.MustCompile(`[.-]([0-9a-f]{8,32})$`)
+	base64AssetHash = regexp.MustCompile(`-([A-Za-z0-9_-]{8})$`)
+)
+
+// only the scripts, styles, fonts and images a bundler emits are hashed, a page keeps its name
+var hashedAssetTypes = map[string]bool{
+	".js": true, ".mjs": true, ".css": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".avif": true, ".ico": true,
+}
+
+const (
+	immutableCacheControl  = "public, max-age=31536000, immutable"
+	revalidateCacheControl = "public, max-age=0, must-revalidate"
+)
+
+// cacheControl lets browsers keep hashed assets forever, their content changes with their name,
+// every other file is revalidated with its ETag
+func cacheControl(filePath, contentType string) string {
+	// a page is never immutable, whatever its name looks like
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "text/html" || !hashedAssetName(path.Base(filePath)) {
+		return revalidateCacheControl
+	}
+
+	return immutableCacheControl
+}
+
+func hashedAssetName(name string) bool {
+	extension := path.Ext(name)
+	if !hashedAssetTypes[strings.ToLower(extension)] {
+		return false
+	}
+	stem := strings.TrimSuffix(name, extension)
+
+	match := hexAssetHash.FindStringSubmatch(stem)
+	if match == nil {
+		match = base64AssetHash.FindStringSubmatch(stem)
+	}
+	// a hash mixes digits and letters, a date or a word like -homepage doesn't
+	return match != nil && strings.ContainsAny(match[1], "0123456789") && strings.Trim(match[1], "0123456789_-") != ""
+}
+
+// etag of a variant of the file, each pre-compressed variant is a representation of its own
+func etag(file *manifestFile, encoding string) string {
+	if file.SHA256 == "" {
+		return ""
+	}
+	if encoding != "" {
+		return `"` + file.SHA256 + "-" + encoding + `"`
+	}
+
+	return `"` + file.SHA256 + `"`
+}
+
+// matchesETag checks the etag against an If-Match or If-None-Match list,
+// weak comparison since W/ only marks how the client got it
+func matchesETag(list, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPreconditions evaluates the conditional headers in the order of RFC 9110,
+// it returns 304 or 412 when the request is answered without the body, 0 otherwise
+func checkPreconditions(request *http.Request, tag string, modified time.Time) int {
+	header := request.Header
+	if ifMatch := header.Get("If-Match"); ifMatch != "" {
+		if tag == "" || !matchesETag(ifMatch, tag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, err := http.ParseTime(header.Get("If-Unmodified-Since")); err == nil && !modified.IsZero() {
+		if modified.Truncate(time.Second).After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		return 0
+	}
+	if ifNoneMatch := header.Get("If-None-Match"); ifNoneMatch != "" {
+		if tag != "" && matchesETag(ifNoneMatch, tag, true) {
+			return http.StatusNotModified
+		}
+		return 0
+	}
+	if since, err := http.ParseTime(header.Get("If-Modified-Since")); err == nil && !modified.IsZero() {
+		if !modified.Truncate(time.Second).After(since) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// byteRange of a single "bytes=" range, multiple ranges are answered with the whole file
+type byteRange struct {
+	start, length int64
+}
+
+// parseRange parses the Range header for a file of size bytes, ok is false when the whole file is served
+func parseRange(value string, size int64) (byteRange, bool, error) {
+	spec, found := strings.CutPrefix(value, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return byteRange{}, false, nil
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return byteRange{}, false, nil
+	}
+
+	if first == "" {
+		// the last N bytes
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return byteRange{}, false, nil
+		}
+		if suffix == 0 || size == 0 {
+			return byteRange{}, false, errUnsatisfiableRange
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return byteRange{start: size - suffix, length: suffix}, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false, nil
+	}
+	if start >= size {
+		return byteRange{}, false, errUnsatisfiableRange
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return byteRange{start: start, length: end - start + 1}, true, nil
+}
+
+// rangeApplies checks the If-Range validator, a changed representation is served whole
+func rangeApplies(request *http.Request, tag string, modified time.Time) bool {
+	ifRange := request.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == tag
+	}
+	if since, err := http.ParseTime(ifRange); err == nil && !modified.IsZero() {
+		return modified.Truncate(time.Second).Equal(since)
+	}
+
+	return false
+}
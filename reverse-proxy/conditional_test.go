@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestCacheControl(t *testing.T) {
+	tests := []struct {
+		filePath    string
+		contentType string
+		want        string
+	}{
+		{"static/js/main.3f2a1b9c.js", "text/javascript; charset=utf-8", immutableCacheControl},
+		{"static/css/main.7c3e1a2b9d4f5e6a.css", "text/css; charset=utf-8", immutableCacheControl},
+		{"_next/static/chunks/framework-2c79e2a64abdb08b.js", "text/javascript; charset=utf-8", immutableCacheControl},
+		{"assets/index-D8nHk3qL.js", "text/javascript; charset=utf-8", immutableCacheControl},
+		{"assets/index-B-x_3kQa.css", "text/css; charset=utf-8", immutableCacheControl},
+		{"assets/inter-4f8e2a1c.woff2", "font/woff2", immutableCacheControl},
+		{"assets/logo-Bq3z9XyA.svg", "image/svg+xml", immutableCacheControl},
+		{"index.html", "text/html; charset=utf-8", revalidateCacheControl},
+		{"blog/post-20240101.html", "text/html; charset=utf-8", revalidateCacheControl},
+		{"about.3f2a1b9c.html", "text/html; charset=utf-8", revalidateCacheControl},
+		{"page-3f2a1b9c", "text/html; charset=utf-8", revalidateCacheControl},
+		{"report-2024v2abc.pdf", "application/pdf", revalidateCacheControl},
+		{"data.3f2a1b9c.json", "application/json", revalidateCacheControl},
+		{"images/photo-20240101.png", "image/png", revalidateCacheControl},
+		{"js/my-homepage.js", "text/javascript; charset=utf-8", revalidateCacheControl},
+		{"js/app-2024v2abc.js", "text/javascript; charset=utf-8", revalidateCacheControl},
+		{"app.js", "text/javascript; charset=utf-8", revalidateCacheControl},
+		{"favicon.ico", "image/x-icon", revalidateCacheControl},
+	}
+
+	for _, test := range tests {
+		got := cacheControl(test.filePath, test.contentType)
+		if got != test.want {
+			t.Errorf("cacheControl(%q, %q) = %q, want %q", test.filePath, test.contentType, got, test.want)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		value     string
+		size      int64
+		want      byteRange
+		ok        bool
+		wantError error
+	}{
+		{"bytes=0-99", 1000, byteRange{0, 100}, true, nil},
+		{"bytes=100-", 1000, byteRange{100, 900}, true, nil},
+		{"bytes=990-2000", 1000, byteRange{990, 10}, true, nil},
+		{"bytes=999-999", 1000, byteRange{999, 1}, true, nil},
+		{"bytes=-100", 1000, byteRange{900, 100}, true, nil},
+		{"bytes=-2000", 1000, byteRange{0, 1000}, true, nil},
+		{"bytes= 5-9", 1000, byteRange{5, 5}, true, nil},
+		{"bytes=1000-", 1000, byteRange{}, false, errUnsatisfiableRange},
+		{"bytes=1000-1001", 1000, byteRange{}, false, errUnsatisfiableRange},
+		{"bytes=-0", 1000, byteRange{}, false, errUnsatisfiableRange},
+		{"bytes=-5", 0, byteRange{}, false, errUnsatisfiableRange},
+		{"bytes=0-", 0, byteRange{}, false, errUnsatisfiableRange},
+		{"bytes=0-1,5-6", 1000, byteRange{}, false, nil},
+		{"bytes=9-5", 1000, byteRange{}, false, nil},
+		{"bytes=-", 1000, byteRange{}, false, nil},
+		{"bytes=a-5", 1000, byteRange{}, false, nil},
+		{"bytes=-5-", 1000, byteRange{}, false, nil},
+		{"bytes=5", 1000, byteRange{}, false, nil},
+		{"items=0-5", 1000, byteRange{}, false, nil},
+		{"", 1000, byteRange{}, false, nil},
+	}
+
+	for _, test := range tests {
+		got, ok, err := parseRange(test.value, test.size)
+		if got != test.want || ok != test.ok || err != test.wantError {
+			t.Errorf("parseRange(%q, %d) = %+v, %v, %v, want %+v, %v, %v", test.value, test.size, got, ok, err, test.want, test.ok, test.wantError)
+		}
+	}
+}
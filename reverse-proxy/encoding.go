@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// encodings the build server pre-compresses, in order of preference
+var preferredEncodings = []string{"br", "gzip"}
+
+var encodingExtensions = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// acceptedEncodings parses the Accept-Encoding header into the q-value of each coding
+func acceptedEncodings(header http.Header) map[string]float64 {
+	accepted := map[string]float64{}
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			fields := strings.Split(part, ";")
+			coding := strings.ToLower(strings.TrimSpace(fields[0]))
+			if coding == "" {
+				continue
+			}
+
+			q := 1.0
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					parsed, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+					if err == nil {
+						q = parsed
+					}
+				}
+			}
+			accepted[coding] = q
+		}
+	}
+
+	return accepted
+}
+
+// chooseEncoding picks the pre-compressed variant of the file the client accepts,
+// "" means the identity variant
+func chooseEncoding(header http.Header, file *manifestFile) string {
+	if file == nil || len(file.Encodings) == 0 {
+		return ""
+	}
+
+	accepted := acceptedEncodings(header)
+	best, bestQ := "", 0.0
+	for _, encoding := range preferredEncodings {
+		if _, ok := file.Encodings[encoding]; !ok {
+			continue
+		}
+
+		q, ok := accepted[encoding]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
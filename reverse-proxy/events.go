@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// routeChange is published by the api server whenever the routing of a project changes
+type routeChange struct {
+	Reason    string   `json:"reason"`
+	ProjectID uint     `json:"projectId"`
+	Slug      string   `json:"slug"`
+	Hosts     []string `json:"hosts"`   // verified custom domains of the project
+	Removed   []string `json:"removed"` // slugs and hostnames which don't route to the project anymore
+	Route     *route   `json:"route"`   // nil when the project has no live deployment
+}
+
+// paths of every host of the project
+func (change *routeChange) paths() []string {
+	paths := []string{projectPath(strconv.FormatUint(uint64(change.ProjectID), 10))}
+	if change.Slug != "" {
+		paths = append(paths, slugPath(change.Slug))
+	}
+	for _, hostname := range change.Hosts {
+		paths = append(paths, hostPath(hostname))
+	}
+
+	return paths
+}
+
+// apply replaces the routes of the project's hosts with the change
+func (table *routeTable) apply(change *routeChange) {
+	var err error
+	if change.Route == nil {
+		err = errNoRoute
+	}
+	expiresAt := time.Now().Add(table.ttl)
+
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	for _, removed := range change.Removed {
+		table.remove(slugPath(removed))
+		table.remove(hostPath(removed))
+	}
+	for _, path := range change.paths() {
+		table.put(path, cachedRoute{route: change.Route, err: err, expiresAt: expiresAt})
+	}
+}
+
+// resync replaces the whole table with the routes of every live project,
+// hosts which aren't in there are looked up again on their next request
+func (table *routeTable) resync() error {
+	var dump struct {
+		Routes []routeChange `json:"routes"`
+	}
+	err := table.getJSON("/internal/routes", &dump)
+	if err != nil {
+		return err
+	}
+
+	entries := map[string]cachedRoute{}
+	expiresAt := time.Now().Add(table.ttl)
+	for i := range dump.Routes {
+		change := &dump.Routes[i]
+		if change.Route == nil {
+			continue
+		}
+		for _, path := range change.paths() {
+			entries[path] = cachedRoute{route: change.Route, expiresAt: expiresAt}
+		}
+	}
+
+	table.mu.Lock()
+	table.entries = entries
+	table.missing = 0
+	table.mu.Unlock()
+
+	return nil
+}
+
+// syncRoutes resyncs the table every interval, in case an event was missed
+func (table *routeTable) syncRoutes(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := table.resync()
+			if err != nil {
+				log.Println("ERROR: unable to resync the routes", err)
+			}
+		}
+	}
+}
+
+// subscribeRouteChanges applies the route changes published on the channel until the context is done.
+// Events published while the connection to redis is down are lost, so the table is resynced
+// every time the subscription is (re)established
+func (table *routeTable) subscribeRouteChanges(ctx context.Context, client *redis.Client, channel string) {
+	subscription := client.Subscribe(ctx, channel)
+	defer subscription.Close()
+
+	for message := range subscription.ChannelWithSubscriptions() {
+		switch message := message.(type) {
+		case *redis.Subscription:
+			if message.Kind != "subscribe" {
+				continue
+			}
+			log.Println("Listening to the route changes on channel", channel)
+			err := table.resync()
+			if err != nil {
+				log.Println("ERROR: unable to resync the routes", err)
+			}
+		case *redis.Message:
+			change := &routeChange{}
+			err := json.Unmarshal([]byte(message.Payload), change)
+			if err != nil {
+				log.Println("ERROR: unable to decode the route change", err)
+				continue
+			}
+			table.apply(change)
+		}
+	}
+}
@@ -1,3 +1,18 @@
 module gitlab.com/harisheoran/scale-mesh/reverse-proxy
 
 go 1.23.1
+
+require (
+	github.com/redis/go-redis/v9 v9.6.1
+	gitlab.com/harisheoran/scale-mesh/siterules v0.0.0
+	golang.org/x/crypto v0.36.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
+)
+
+replace gitlab.com/harisheoran/scale-mesh/siterules => ../siterules
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// securityHeaders of a project override the defaults of the proxy, empty keeps the default and off sends none
+type securityHeaders struct {
+	ContentSecurityPolicy   string `json:"contentSecurityPolicy"`
+	StrictTransportSecurity string `json:"strictTransportSecurity"`
+	ReferrerPolicy          string `json:"referrerPolicy"`
+	PermissionsPolicy       string `json:"permissionsPolicy"`
+	FrameOptions            string `json:"frameOptions"`
+}
+
+// headers the proxy adds to the responses of the sites, a proxied origin sending its own replaces them
+var siteHeaderNames = []string{
+	"X-Content-Type-Options",
+	"Content-Security-Policy",
+	"Strict-Transport-Security",
+	"Referrer-Policy",
+	"Permissions-Policy",
+	"X-Frame-Options",
+}
+
+// applySecurityHeaders adds the security headers of the site before anything is written,
+// the _headers file of the deployment replaces them for the files it matches
+func applySecurityHeaders(w http.ResponseWriter, request *http.Request, projectRoute *route) {
+	site := securityHeaders{}
+	if projectRoute.Headers != nil {
+		site = *projectRoute.Headers
+	}
+
+	header := w.Header()
+	header.Set("X-Content-Type-Options", "nosniff")
+	setSiteHeader(header, "Content-Security-Policy", site.ContentSecurityPolicy, config.contentSecurityPolicy)
+	setSiteHeader(header, "Referrer-Policy", site.ReferrerPolicy, config.referrerPolicy)
+	setSiteHeader(header, "Permissions-Policy", site.PermissionsPolicy, config.permissionsPolicy)
+	setSiteHeader(header, "X-Frame-Options", site.FrameOptions, config.frameOptions)
+	// browsers ignore HSTS over plain HTTP, the default comes from -hsts-max-age on the HTTPS listener
+	if requestScheme(request) == "https" {
+		setSiteHeader(header, "Strict-Transport-Security", site.StrictTransportSecurity, "")
+	}
+}
+
+func setSiteHeader(header http.Header, name, value, platformDefault string) {
+	switch value {
+	case "off":
+		header.Del(name)
+	case "":
+		if platformDefault != "" {
+			header.Set(name, platformDefault)
+		}
+	default:
+		header.Set(name, value)
+	}
+}
+
+// allowedOrigin is the Access-Control-Allow-Origin of the request, empty when its origin isn't allowed
+func allowedOrigin(origins []string, origin string) string {
+	if origin == "" || origin == "null" {
+		return ""
+	}
+	for _, allowed := range origins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// handleCORS lets the allowed origins read the responses of the site and answers their preflight
+// requests, it reports whether the request was answered
+func handleCORS(w http.ResponseWriter, request *http.Request, projectRoute *route) bool {
+	if len(projectRoute.CORSOrigins) == 0 {
+		return false
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	allowed := allowedOrigin(projectRoute.CORSOrigins, request.Header.Get("Origin"))
+	if allowed == "" {
+		return false
+	}
+	header.Set("Access-Control-Allow-Origin", allowed)
+
+	method := request.Header.Get("Access-Control-Request-Method")
+	if request.Method != http.MethodOptions || method == "" {
+		return false
+	}
+	// the methods and headers past the static files are up to the origins of the proxy rules
+	header.Set("Access-Control-Allow-Methods", method)
+	if requested := request.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		header.Set("Access-Control-Allow-Headers", requested)
+	}
+	header.Set("Access-Control-Max-Age", "600")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseBaseDomains reads the comma separated -base-domain list
+func parseBaseDomains(value string) []string {
+	domains := []string{}
+	for _, domain := range strings.Split(value, ",") {
+		domain = normalizeHost(domain)
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// parseTrustedProxies reads the comma separated -trusted-proxies list of CIDRs or addresses
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			address, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(address, address.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func trustedAddress(value string) bool {
+	address, err := netip.ParseAddr(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+	address = address.Unmap()
+	for _, prefix := range config.trustedProxies {
+		if prefix.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteAddress(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// fromTrustedProxy reports a request forwarded by a load balancer whose X-Forwarded headers are believed
+func fromTrustedProxy(request *http.Request) bool {
+	return len(config.trustedProxies) > 0 && trustedAddress(remoteAddress(request))
+}
+
+// requestHost is the host the client asked for, with its port if any
+func requestHost(request *http.Request) string {
+	if fromTrustedProxy(request) {
+		if forwarded, _, _ := strings.Cut(request.Header.Get("X-Forwarded-Host"), ","); strings.TrimSpace(forwarded) != "" {
+			return strings.TrimSpace(forwarded)
+		}
+	}
+	return request.Host
+}
+
+func requestScheme(request *http.Request) string {
+	if fromTrustedProxy(request) {
+		if forwarded, _, _ := strings.Cut(request.Header.Get("X-Forwarded-Proto"), ","); strings.TrimSpace(forwarded) != "" {
+			return strings.ToLower(strings.TrimSpace(forwarded))
+		}
+	}
+	if request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// clientIP is the address of the visitor, the last hop of X-Forwarded-For
+// which isn't one of the trusted proxies
+func clientIP(request *http.Request) string {
+	address := remoteAddress(request)
+	if !fromTrustedProxy(request) {
+		return address
+	}
+
+	hops := strings.Split(strings.Join(request.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !trustedAddress(hop) {
+			return hop
+		}
+		address = hop
+	}
+	return address
+}
+
+// normalizeHost drops the port and the trailing dot of the host, in lower case
+func normalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// splitBaseDomain splits a {label}.{baseDomain} hostname, the longest matching base domain wins
+func splitBaseDomain(hostname string) (label, baseDomain string, ok bool) {
+	for _, domain := range config.baseDomains {
+		if prefix, found := strings.CutSuffix(hostname, "."+domain); found && len(domain) > len(baseDomain) {
+			label, baseDomain, ok = prefix, domain, true
+		}
+	}
+	return label, baseDomain, ok
+}
+
+func isBaseDomain(hostname string) bool {
+	for _, domain := range config.baseDomains {
+		if hostname == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func underBaseDomain(hostname string) bool {
+	_, _, ok := splitBaseDomain(hostname)
+	return ok || isBaseDomain(hostname)
+}
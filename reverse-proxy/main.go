@@ -1,30 +1,622 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gitlab.com/harisheoran/scale-mesh/siterules"
+	"golang.org/x/crypto/acme"
+)
+
+type proxyConfig struct {
+	address               string
+	baseDomains           []string
+	pathRouting           bool
+	trustedProxies        []netip.Prefix
+	apiURL                string
+	apiPublicURL          string
+	storageURL            string
+	routesTTL             time.Duration
+	routesResync          time.Duration
+	redisAddress          string
+	routesChannel         string
+	allowPrivateUpstreams bool
+	// artifact cache, the disk tier is disabled when cacheDir is empty
+	cacheMemorySize    int64
+	cacheMemoryMaxFile int64
+	cacheDir           string
+	cacheDiskSize      int64
+	cacheDiskMaxFile   int64
+	metricsAddress     string
+	// circuit breaker of the storage, disabled when storageFailures is 0
+	storageTimeout  time.Duration
+	storageFailures int
+	storageCooldown time.Duration
+	// app runner of the app deployments
+	appRunnerURL string
+	appsTTL      time.Duration
+	// HTTPS listener, disabled when tlsAddress is empty
+	tlsAddress            string
+	tlsCert               string
+	tlsKey                string
+	redirectHTTPS         bool
+	hstsMaxAge            time.Duration
+	hstsIncludeSubdomains bool
+	// security headers of the sites, disabled when empty, projects may override them
+	contentSecurityPolicy string
+	referrerPolicy        string
+	permissionsPolicy     string
+	frameOptions          string
+	// Retry-After of the maintenance and suspension pages when the project sets none
+	retryAfter time.Duration
+	// certificate issuance for custom domains, disabled when acmeDirectory is empty
+	acmeDirectory   string
+	acmeEmail       string
+	acmeCache       string
+	acmeCA          string
+	acmeRenewBefore time.Duration
+	// token bucket rates in requests per second, disabled if 0, projects may override them
+	rateHost        float64
+	rateHostBurst   int
+	rateIP          float64
+	rateIPBurst     int
+	rateLimitRedis  bool
+	maxRequestBody  int64
+	maxConcurrent   int64
+	maxConcurrentIP int64
+	// access log on stdout and the traffic counters sent to the api server
+	accessLog         bool
+	analyticsInterval time.Duration
+}
+
+var (
+	routes    *routeTable
+	artifacts *artifactStore
+	cache     *artifactCache
+	manifests *manifestStore
+	config    proxyConfig
 )
 
 func main() {
-	// starting the server
-	http.HandleFunc("/", mainHandler)
-	err := http.ListenAndServe(":8080", nil)
+	flag.StringVar(&config.address, "address", ":8080", "Port of the reverse proxy")
+	baseDomains := flag.String("base-domain", "localhost", "Comma separated domains of the {slug}.{baseDomain} sites, any other host is looked up as a custom domain")
+	flag.BoolVar(&config.pathRouting, "path-routing", false, "Also serve the sites as {baseDomain}/{slug}/, without wildcard DNS")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma separated CIDRs of the load balancers whose X-Forwarded-Host, X-Forwarded-Proto and X-Forwarded-For are trusted")
+	flag.StringVar(&config.apiURL, "api-url", "http://127.0.0.1:9000", "URL of the api server to look up the deployment of a project")
+	flag.StringVar(&config.apiPublicURL, "api-public-url", "", "URL of the api server reachable from the browsers, to sign in to team protected sites, -api-url if empty")
+	flag.StringVar(&config.storageURL, "storage-url", "https://scale-mesh-s3.s3.ap-south-1.amazonaws.com", "Public URL of the artifact bucket")
+	flag.DurationVar(&config.routesTTL, "routes-ttl", 30*time.Second, "How long a looked up route is cached")
+	flag.DurationVar(&config.routesResync, "routes-resync", 5*time.Minute, "Interval of the full resync of the routes with the api server, disabled if 0")
+	flag.StringVar(&config.redisAddress, "redis-address", "", "Redis to subscribe to the route changes of the api server, disabled if empty")
+	flag.StringVar(&config.routesChannel, "routes-channel", "routes", "Redis channel of the route changes")
+	flag.BoolVar(&config.allowPrivateUpstreams, "allow-private-upstreams", false, "Allow _redirects proxy rules to target private addresses, for local testing")
+	flag.Int64Var(&config.cacheMemorySize, "cache-memory-size", 64<<20, "Bytes of artifacts cached in memory")
+	flag.Int64Var(&config.cacheMemoryMaxFile, "cache-memory-max-file", 512<<10, "Largest artifact cached in memory, larger ones go to the disk cache")
+	flag.StringVar(&config.cacheDir, "cache-dir", "", "Directory of the disk cache, disabled if empty")
+	flag.Int64Var(&config.cacheDiskSize, "cache-disk-size", 1<<30, "Bytes of artifacts cached on disk")
+	flag.Int64Var(&config.cacheDiskMaxFile, "cache-disk-max-file", 100<<20, "Largest artifact cached on disk")
+	flag.DurationVar(&config.storageTimeout, "storage-timeout", 10*time.Second, "How long to wait for the storage to start answering")
+	flag.IntVar(&config.storageFailures, "storage-breaker-failures", 5, "Failures of the storage in a row which open its circuit breaker, disabled if 0")
+	flag.DurationVar(&config.storageCooldown, "storage-breaker-cooldown", 30*time.Second, "How long the open circuit breaker serves from the cache only before probing the storage again")
+	flag.StringVar(&config.appRunnerURL, "app-runner-url", "http://127.0.0.1:9300", "URL of the app runner to look up the running app of a deployment")
+	flag.DurationVar(&config.appsTTL, "apps-ttl", 5*time.Second, "How long the upstream of a running app is cached")
+	flag.StringVar(&config.metricsAddress, "metrics-address", "", "Address serving the metrics at /metrics, e.g. 127.0.0.1:9091, disabled if empty")
+	flag.StringVar(&config.tlsAddress, "tls-address", "", "Port of the HTTPS listener, e.g. :8443, HTTPS is disabled if empty")
+	flag.StringVar(&config.tlsCert, "tls-cert", "../tls/cert.pem", "Certificate served for the base domain and hosts without an ACME certificate")
+	flag.StringVar(&config.tlsKey, "tls-key", "../tls/key.pem", "Private key of -tls-cert")
+	flag.BoolVar(&config.redirectHTTPS, "redirect-https", true, "Redirect plain HTTP requests to HTTPS when the HTTPS listener is enabled")
+	flag.DurationVar(&config.hstsMaxAge, "hsts-max-age", 0, "max-age of the Strict-Transport-Security header on HTTPS responses, disabled if 0")
+	flag.BoolVar(&config.hstsIncludeSubdomains, "hsts-include-subdomains", false, "Add includeSubDomains to the Strict-Transport-Security header")
+	flag.StringVar(&config.contentSecurityPolicy, "content-security-policy", "", "Default Content-Security-Policy of the sites, disabled if empty")
+	flag.StringVar(&config.referrerPolicy, "referrer-policy", "strict-origin-when-cross-origin", "Default Referrer-Policy of the sites, disabled if empty")
+	flag.StringVar(&config.permissionsPolicy, "permissions-policy", "", "Default Permissions-Policy of the sites, disabled if empty")
+	flag.StringVar(&config.frameOptions, "frame-options", "SAMEORIGIN", "Default X-Frame-Options of the sites, disabled if empty")
+	flag.DurationVar(&config.retryAfter, "retry-after", 5*time.Minute, "Retry-After of the maintenance and suspension pages when the project sets none, not sent if 0")
+	flag.StringVar(&config.acmeDirectory, "acme-directory", "", "ACME directory URL to issue certificates for custom domains, e.g. "+acme.LetsEncryptURL+", disabled if empty")
+	flag.StringVar(&config.acmeEmail, "acme-email", "", "Contact email of the ACME account")
+	flag.StringVar(&config.acmeCache, "acme-cache", "certs", "Directory storing the ACME account and the issued certificates")
+	flag.StringVar(&config.acmeCA, "acme-ca", "", "PEM file of the CA which signed the ACME directory certificate, for a local ACME server like Pebble")
+	flag.DurationVar(&config.acmeRenewBefore, "acme-renew-before", 30*24*time.Hour, "How long before the expiry a certificate is renewed")
+	flag.Float64Var(&config.rateHost, "rate-host", 200, "Requests per second a site accepts from all clients, disabled if 0")
+	flag.IntVar(&config.rateHostBurst, "rate-host-burst", 400, "Requests a site accepts in a burst above -rate-host")
+	flag.Float64Var(&config.rateIP, "rate-ip", 20, "Requests per second a site accepts from one client IP, disabled if 0")
+	flag.IntVar(&config.rateIPBurst, "rate-ip-burst", 40, "Requests a site accepts from one client IP in a burst above -rate-ip")
+	flag.BoolVar(&config.rateLimitRedis, "rate-limit-redis", false, "Share the rate limits with the other proxy instances through -redis-address")
+	flag.Int64Var(&config.maxRequestBody, "max-request-body", 10<<20, "Largest request body in bytes, unlimited if 0")
+	flag.Int64Var(&config.maxConcurrent, "max-concurrent", 1000, "Requests served at the same time, unlimited if 0")
+	flag.Int64Var(&config.maxConcurrentIP, "max-concurrent-per-ip", 50, "Requests of one client IP served at the same time, unlimited if 0")
+	flag.BoolVar(&config.accessLog, "access-log", true, "Log every request as a JSON line on stdout")
+	flag.DurationVar(&config.analyticsInterval, "analytics-interval", time.Minute, "Interval the traffic counters of the projects are sent to the api server, disabled if 0")
+	flag.Parse()
+
+	config.baseDomains = parseBaseDomains(*baseDomains)
+	var err error
+	config.trustedProxies, err = parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatal("ERROR: invalid -trusted-proxies ", err)
+	}
+
+	if config.apiPublicURL == "" {
+		config.apiPublicURL = config.apiURL
+	}
+	config.apiPublicURL = strings.TrimSuffix(config.apiPublicURL, "/")
+
+	routes = newRouteTable(config.apiURL, os.Getenv("INTERNAL_TOKEN"), config.routesTTL)
+	accessKey = []byte(os.Getenv("ACCESS_SIGNING_KEY"))
+	if len(accessKey) == 0 {
+		log.Println("WARNING: ACCESS_SIGNING_KEY is not set, the sites protected by a password or team access are unavailable")
+	}
+	runningApps = newAppTable(strings.TrimSuffix(config.appRunnerURL, "/"), os.Getenv("INTERNAL_TOKEN"), config.appsTTL)
+	artifacts = newArtifactStore(strings.TrimSuffix(config.storageURL, "/"), config.storageTimeout, newCircuitBreaker(config.storageFailures, config.storageCooldown))
+
+	if config.routesResync > 0 {
+		go routes.syncRoutes(context.Background(), config.routesResync)
+	}
+	var redisClient *redis.Client
+	if config.redisAddress != "" {
+		redisClient = redis.NewClient(&redis.Options{
+			Addr:     config.redisAddress,
+			Password: os.Getenv("REDIS_PASSWORD"),
+			DB:       0,
+		})
+		go routes.subscribeRouteChanges(context.Background(), redisClient, config.routesChannel)
+	}
+
+	var rateLimiter limiter = newLocalLimiter()
+	if config.rateLimitRedis {
+		if redisClient == nil {
+			log.Fatal("ERROR: -rate-limit-redis needs -redis-address")
+		}
+		rateLimiter = &redisLimiter{client: redisClient, fallback: rateLimiter.(*localLimiter)}
+	}
+	limits = newRequestLimits(rateLimiter, config.maxRequestBody, config.maxConcurrent, config.maxConcurrentIP)
+
+	if config.accessLog {
+		accessLog = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+	if config.analyticsInterval > 0 {
+		traffic = newTrafficCounter(config.apiURL, os.Getenv("INTERNAL_TOKEN"))
+		go traffic.flushTraffic(context.Background(), config.analyticsInterval)
+	}
+	siteHandler := withAccessLog(limits.withRequestLimits(http.HandlerFunc(mainHandler)))
+
+	cache, err = newArtifactCache(artifacts, config.cacheMemorySize, config.cacheMemoryMaxFile, config.cacheDir, config.cacheDiskSize, config.cacheDiskMaxFile)
 	if err != nil {
-		log.Fatal("ERROR: unable to start the server", err)
+		log.Fatal("ERROR: unable to open the artifact cache ", err)
+	}
+	// manifests and rule files are cached like the artifacts, so the sites are served while the storage is down
+	manifests = newManifestStore(cache, 1000)
+
+	if config.metricsAddress != "" {
+		metrics := http.NewServeMux()
+		metrics.HandleFunc("/metrics", metricsHandler)
+		go func() {
+			err := http.ListenAndServe(config.metricsAddress, metrics)
+			if err != nil {
+				log.Fatal("ERROR: unable to start the metrics server", err)
+			}
+		}()
+	}
+
+	if config.tlsAddress == "" {
+		// starting the server
+		http.Handle("/", siteHandler)
+		err := http.ListenAndServe(config.address, nil)
+		if err != nil {
+			log.Fatal("ERROR: unable to start the server", err)
+		}
+		return
+	}
+
+	certificates := newCertStore()
+	if config.tlsCert != "" {
+		err := certificates.load(config.tlsCert, config.tlsKey)
+		if err != nil {
+			log.Fatal("ERROR: unable to load the certificate ", err)
+		}
+	}
+	if config.acmeDirectory != "" {
+		manager, err := newACMEManager(config.acmeDirectory, config.acmeEmail, config.acmeCache, config.acmeCA, config.acmeRenewBefore)
+		if err != nil {
+			log.Fatal("ERROR: unable to set up the ACME client ", err)
+		}
+		certificates.acme = manager
+	}
+
+	// the plain HTTP listener redirects to HTTPS and answers the HTTP-01 challenges
+	plainHandler := siteHandler
+	if config.redirectHTTPS {
+		plainHandler = http.HandlerFunc(redirectToHTTPS)
+	}
+	if certificates.acme != nil {
+		plainHandler = certificates.acme.HTTPHandler(plainHandler)
+	}
+	go func() {
+		err := http.ListenAndServe(config.address, plainHandler)
+		if err != nil {
+			log.Fatal("ERROR: unable to start the server", err)
+		}
+	}()
+
+	tlsConfig := &tls.Config{
+		GetCertificate: certificates.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+	}
+	server := &http.Server{
+		Addr:      config.tlsAddress,
+		Handler:   withHSTS(siteHandler, config.hstsMaxAge, config.hstsIncludeSubdomains),
+		TLSConfig: tlsConfig,
+	}
+	err = server.ListenAndServeTLS("", "")
+	if err != nil {
+		log.Fatal("ERROR: unable to start the HTTPS server", err)
 	}
 }
 
+// serve the requested file of the live deployment of the project under the project's own hostname
 func mainHandler(w http.ResponseWriter, request *http.Request) {
+	hostname := normalizeHost(requestHost(request))
+
+	var route *route
+	var numericHost bool
+	var err error
+	prefix := ""
+	if config.pathRouting && isBaseDomain(hostname) {
+		// path-based routing, the site is the first segment of the path
+		label, rest := splitSitePath(request.URL.Path)
+		if label != "" && rest == "" {
+			target := "/" + label + "/"
+			if request.URL.RawQuery != "" {
+				target += "?" + request.URL.RawQuery
+			}
+			http.Redirect(w, request, target, http.StatusMovedPermanently)
+			return
+		}
+		route, numericHost, err = lookupLabel(label)
+		prefix = "/" + label
+		request = stripSitePrefix(request, prefix, rest)
+	} else {
+		route, numericHost, err = lookupRoute(hostname)
+	}
+
+	if err == nil && numericHost && route.Slug != "" {
+		redirectToSlug(w, request, route.Slug, prefix)
+		return
+	}
+	if err == errNoRoute {
+		siteNotFound(w, request)
+		return
+	} else if err != nil {
+		log.Println("ERROR: unable to look up the route of host", hostname, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	if prefix != "" {
+		// documents are rewritten under the prefix, which their pre-compressed variants can't be
+		request.Header.Del("Accept-Encoding")
+		prefixed := &prefixedResponse{ResponseWriter: w, request: request, prefix: prefix}
+		defer prefixed.finish()
+		w = prefixed
+	}
+
+	route = servedRoute(w, request, route)
+	noteRoute(request, route)
+	if prefix != "" {
+		var ok bool
+		if route, ok = sharedOriginRoute(w, route); !ok {
+			return
+		}
+	}
+	if route.Preview {
+		// previews are for reviewers, not for search engines
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+
+	applySecurityHeaders(w, request, route)
+	if siteUnavailable(w, request, route) {
+		return
+	}
+
+	if !limits.allowRequest(w, request, route) {
+		return
+	}
+	// preflight requests carry no credentials, they are answered before the access check
+	if handleCORS(w, request, route) {
+		return
+	}
+	if !checkAccess(w, request, route) {
+		return
+	}
+	if route.Access != nil {
+		w = &privateResponse{ResponseWriter: w}
+	}
+	if route.Runtime == runtimeApp {
+		serveApp(w, request, route)
+		return
+	}
+
+	deploymentManifest, err := manifests.get(request.Context(), route.deploymentKey())
+	if errors.Is(err, errStorageUnavailable) {
+		storageUnavailable(w)
+		return
+	} else if err != nil {
+		log.Println("ERROR: unable to fetch the manifest of deployment", route.DeploymentID, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	rules, err := manifests.siteRules(request.Context(), route.deploymentKey(), deploymentManifest)
+	if err != nil {
+		log.Println("ERROR: unable to load the rules of deployment", route.DeploymentID, err)
+		rules = &siterules.Rules{}
+	}
+
+	// the manifest answers whether the file exists without a round trip to the storage
+	_, fileExists := deploymentManifest.file(requestFilePath(request.URL.Path))
+	if rule, target, ok := rules.Match(request.URL.Path, request.URL.Query(), fileExists); ok {
+		applyRedirectRule(w, request, route, deploymentManifest, rules, rule, target)
+		return
+	}
+
+	if !allowedMethod(w, request) {
+		return
+	}
+	serveResolution(w, request, route, rules, resolve(deploymentManifest, route, request.URL.Path))
+}
+
+// hosts under a base domain are addressed by a label, any other host is a custom domain.
+// numericHost reports a {projectID}.{baseDomain} host, the address of the sites before slugs
+func lookupRoute(hostname string) (found *route, numericHost bool, err error) {
+	if label, _, ok := splitBaseDomain(hostname); ok {
+		if strings.Contains(label, ".") {
+			return nil, false, errNoRoute
+		}
+		return lookupLabel(label)
+	}
+	if isBaseDomain(hostname) {
+		return nil, false, errNoRoute
+	}
+
+	found, err = routes.lookupHost(hostname)
+	return found, false, err
+}
+
+// lookupLabel finds the site of a label, the project slug or {deploymentID}--{slug} for
+// the preview of a deployment, as the subdomain of a base domain or the first segment of the path
+func lookupLabel(label string) (found *route, numericHost bool, err error) {
+	// the api server keeps the reserved labels, no project has one of them as its slug
+	if label == "" {
+		return nil, false, errNoRoute
+	}
+	if strings.Trim(label, "0123456789") == "" {
+		found, err = routes.lookupProject(label)
+		return found, true, err
+	}
+	if deploymentID, slug, ok := strings.Cut(label, "--"); ok {
+		if deploymentID == "" || strings.Trim(deploymentID, "0123456789") != "" || slug == "" {
+			return nil, false, errNoRoute
+		}
+		found, err = routes.lookupPreview(deploymentID, slug)
+		return found, false, err
+	}
+
+	found, err = routes.lookupSlug(label)
+	return found, false, err
+}
+
+// redirectToSlug moves a request for a numeric project label to the slug of the project,
+// prefix is the /{label} of the path-based routing, empty for a host
+func redirectToSlug(w http.ResponseWriter, request *http.Request, slug, prefix string) {
+	if prefix != "" {
+		http.Redirect(w, request, "/"+slug+request.URL.RequestURI(), http.StatusMovedPermanently)
+		return
+	}
+
+	_, baseDomain, _ := splitBaseDomain(normalizeHost(requestHost(request)))
+	host := slug + "." + baseDomain
+	if _, port, err := net.SplitHostPort(requestHost(request)); err == nil {
+		host = net.JoinHostPort(host, port)
+	}
 
-	baseDomain := "https://scale-mesh-s3.s3.ap-south-1.amazonaws.com/__output/"
+	http.Redirect(w, request, requestScheme(request)+"://"+host+request.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// only proxy rules accept other methods than GET and HEAD
+func allowedMethod(w http.ResponseWriter, request *http.Request) bool {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// apply the matched _redirects rule: redirect, rewrite to another file, proxy to another origin
+// or serve a custom page with the rule's status
+func applyRedirectRule(w http.ResponseWriter, request *http.Request, projectRoute *route, deploymentManifest *manifest, rules *siterules.Rules, rule *siterules.RedirectRule, target string) {
+	if rule.IsProxy() {
+		proxyToURL(w, request, projectRoute, target)
+		return
+	}
+
+	if !allowedMethod(w, request) {
+		return
+	}
 
-	hostname := request.Host
-	projectID := strings.Split(hostname, ".")[0]
-	redirectTo := baseDomain + projectID + "/index.html"
+	if rule.Status >= 300 && rule.Status < 400 {
+		if !strings.Contains(target, "?") && request.URL.RawQuery != "" {
+			target += "?" + request.URL.RawQuery
+		}
+		http.Redirect(w, request, target, rule.Status)
+		return
+	}
+
+	targetPath, _, _ := strings.Cut(target, "?")
+	resolved := resolve(deploymentManifest, projectRoute, targetPath)
+	if resolved.file != nil && rule.Status != http.StatusOK {
+		resolved.status = rule.Status
+	}
+	serveResolution(w, request, projectRoute, rules, resolved)
+}
 
-	log.Println("redirecting to:", redirectTo)
+// answer the request with the resolved file, redirect or not found
+func serveResolution(w http.ResponseWriter, request *http.Request, projectRoute *route, rules *siterules.Rules, resolved resolution) {
+	if resolved.redirect != "" {
+		target := resolved.redirect
+		if request.URL.RawQuery != "" {
+			target += "?" + request.URL.RawQuery
+		}
+		http.Redirect(w, request, target, resolved.status)
+		return
+	}
+	if resolved.file == nil {
+		http.NotFound(w, request)
+		return
+	}
 
-	http.Redirect(w, request, redirectTo, http.StatusSeeOther)
+	serveArtifact(w, request, projectRoute.deploymentKey(), resolved.file, resolved.status, rules.HeadersFor(request.URL.Path))
+}
 
+// requestFilePath maps the URL path to a path relative to the deployment root,
+// the path is cleaned first so it can't escape the deployment
+func requestFilePath(urlPath string) string {
+	cleaned := path.Clean("/" + urlPath)
+	if strings.HasSuffix(urlPath, "/") {
+		cleaned = path.Join(cleaned, "index.html")
+	}
+
+	return strings.TrimPrefix(cleaned, "/")
+}
+
+// stream a file of the deployment from the storage with the status code and the headers
+// of the _headers file, in the pre-compressed variant the client accepts.
+// Files served as themselves answer conditional and range requests, error pages don't
+func serveArtifact(w http.ResponseWriter, request *http.Request, deploymentID string, file *manifestFile, status int, ruleHeaders http.Header) {
+	encoding := chooseEncoding(request.Header, file)
+	key := artifactKey(deploymentID, file.Path+encodingExtensions[encoding])
+
+	header := w.Header()
+	header.Set("Content-Type", file.ContentType)
+	if len(file.Encodings) > 0 {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+	}
+
+	tag := etag(file, encoding)
+	if status == http.StatusOK {
+		if tag != "" {
+			header.Set("ETag", tag)
+		}
+		if !file.modified.IsZero() {
+			header.Set("Last-Modified", file.modified.UTC().Format(http.TimeFormat))
+		}
+		header.Set("Cache-Control", cacheControl(file.Path, file.ContentType))
+	}
+	for name, values := range ruleHeaders {
+		// the framing of the response is owned by the proxy
+		if name == "Content-Length" || name == "Content-Encoding" {
+			continue
+		}
+		header[name] = values
+	}
+
+	// the validators are known from the manifest, a 304 doesn't touch the storage
+	if status == http.StatusOK {
+		if code := checkPreconditions(request, tag, file.modified); code != 0 {
+			header.Del("Content-Type")
+			w.WriteHeader(code)
+			return
+		}
+	}
+
+	object, err := cache.open(request.Context(), key)
+	if errors.Is(err, errArtifactNotFound) {
+		log.Println("ERROR: artifact listed in the manifest is missing from the storage", key)
+		http.NotFound(w, request)
+		return
+	} else if errors.Is(err, errStorageUnavailable) {
+		storageUnavailable(w)
+		return
+	} else if err != nil {
+		log.Println("ERROR: unable to fetch the artifact", key, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	defer object.body.Close()
+
+	// under a prefix the documents are rewritten, only the other files are served in ranges
+	ranges := sitePrefix(request) == "" || !rewritableType(file.ContentType)
+	if status == http.StatusOK && object.size >= 0 && ranges {
+		header.Set("Accept-Ranges", "bytes")
+		if request.Method == http.MethodGet && request.Header.Get("Range") != "" && rangeApplies(request, tag, file.modified) {
+			requested, ok, err := parseRange(request.Header.Get("Range"), object.size)
+			if err != nil {
+				header.Set("Content-Range", "bytes */"+strconv.FormatInt(object.size, 10))
+				http.Error(w, http.StatusText(http.StatusRequestedRangeNotSatisfiable), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			if ok {
+				servePartial(w, object, key, requested)
+				return
+			}
+		}
+	}
+
+	if object.size >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(object.size, 10))
+	}
+	w.WriteHeader(status)
+
+	if request.Method == http.MethodHead {
+		return
+	}
+
+	_, err = io.Copy(w, object.body)
+	if err != nil {
+		log.Println("ERROR: streaming the artifact", key, err)
+	}
+}
+
+// servePartial answers a range request with the requested bytes of the artifact
+func servePartial(w http.ResponseWriter, object *artifact, key string, requested byteRange) {
+	var err error
+	if seeker, ok := object.body.(io.Seeker); ok {
+		_, err = seeker.Seek(requested.start, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, object.body, requested.start)
+	}
+	if err != nil {
+		log.Println("ERROR: unable to skip to the range of the artifact", key, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	end := requested.start + requested.length - 1
+	header := w.Header()
+	header.Set("Content-Range", "bytes "+strconv.FormatInt(requested.start, 10)+"-"+strconv.FormatInt(end, 10)+"/"+strconv.FormatInt(object.size, 10))
+	header.Set("Content-Length", strconv.FormatInt(requested.length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	_, err = io.CopyN(w, object.body, requested.length)
+	if err != nil {
+		log.Println("ERROR: streaming the range of the artifact", key, err)
+	}
 }
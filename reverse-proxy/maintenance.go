@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// maintenanceRoute takes a site offline, except for the allowed client IPs
+type maintenanceRoute struct {
+	Message    string   `json:"message"`
+	RetryAfter int      `json:"retryAfter"` // seconds, 0 uses -retry-after
+	AllowedIPs []string `json:"allowedIps"` // addresses or CIDRs
+}
+
+// suspensionRoute takes a site offline for everyone
+type suspensionRoute struct {
+	Message string `json:"message"`
+}
+
+// allows reports whether the client IP bypasses the maintenance
+func (m *maintenanceRoute) allows(ip string) bool {
+	address, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	address = address.Unmap()
+
+	for _, allowed := range m.AllowedIPs {
+		if strings.Contains(allowed, "/") {
+			prefix, err := netip.ParsePrefix(allowed)
+			if err == nil && prefix.Contains(address) {
+				return true
+			}
+		} else if allowedAddress, err := netip.ParseAddr(allowed); err == nil && allowedAddress.Unmap() == address {
+			return true
+		}
+	}
+	return false
+}
+
+// siteUnavailable answers with the 503 page of a suspended site or a site in maintenance,
+// it reports whether the request was answered
+func siteUnavailable(w http.ResponseWriter, request *http.Request, projectRoute *route) bool {
+	retryAfter := int(config.retryAfter.Seconds())
+	title, message := "", ""
+	switch {
+	case projectRoute.Suspension != nil:
+		title, message = "Site suspended", projectRoute.Suspension.Message
+		if message == "" {
+			message = "This site has been suspended by the platform."
+		}
+	case projectRoute.Maintenance != nil && !projectRoute.Maintenance.allows(clientIP(request)):
+		title, message = "Under maintenance", projectRoute.Maintenance.Message
+		if message == "" {
+			message = "This site is down for maintenance and will be back soon."
+		}
+		if projectRoute.Maintenance.RetryAfter > 0 {
+			retryAfter = projectRoute.Maintenance.RetryAfter
+		}
+	default:
+		return false
+	}
+
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	renderPage(w, http.StatusServiceUnavailable, title, message)
+	return true
+}
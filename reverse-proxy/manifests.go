@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"gitlab.com/harisheoran/scale-mesh/siterules"
+)
+
+// manifest written by the build server beside the artifacts of every deployment
+type manifest struct {
+	ProjectID    string         `json:"projectId"`
+	DeploymentID string         `json:"deploymentId"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	TotalFiles   int            `json:"totalFiles"`
+	TotalSize    int64          `json:"totalSize"`
+	StoredSize   int64          `json:"storedSize"`
+	Files        []manifestFile `json:"files"`
+
+	byPath    map[string]*manifestFile
+	ruleFiles map[string]bool
+
+	// compiled _redirects and _headers, loaded on first use
+	rulesMu sync.Mutex
+	rules   *siterules.Rules
+}
+
+type manifestFile struct {
+	Path        string           `json:"path"`
+	Size        int64            `json:"size"`
+	SHA256      string           `json:"sha256"`
+	ContentType string           `json:"contentType"`
+	Encodings   map[string]int64 `json:"encodings,omitempty"`
+
+	modified time.Time // creation of the deployment, the Last-Modified of its files
+}
+
+// look up a file by its path relative to the deployment root
+func (m *manifest) file(path string) (*manifestFile, bool) {
+	file, ok := m.byPath[path]
+	return file, ok
+}
+
+// objectOpener opens the objects of the artifact storage, directly or through the artifact cache
+type objectOpener interface {
+	open(ctx context.Context, key string) (*artifact, error)
+}
+
+// manifestStore fetches manifests from the artifact storage, deployments are immutable
+// so a manifest is cached until it is evicted to keep at most maxEntries
+type manifestStore struct {
+	storage    objectOpener
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*manifest
+}
+
+func newManifestStore(storage objectOpener, maxEntries int) *manifestStore {
+	return &manifestStore{
+		storage:    storage,
+		maxEntries: maxEntries,
+		entries:    map[string]*manifest{},
+	}
+}
+
+func (store *manifestStore) get(ctx context.Context, deploymentID string) (*manifest, error) {
+	store.mu.Lock()
+	cached, ok := store.entries[deploymentID]
+	store.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	fetched, err := store.fetch(ctx, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	store.mu.Lock()
+	if len(store.entries) >= store.maxEntries {
+		for key := range store.entries {
+			delete(store.entries, key)
+			break
+		}
+	}
+	store.entries[deploymentID] = fetched
+	store.mu.Unlock()
+
+	return fetched, nil
+}
+
+func (store *manifestStore) fetch(ctx context.Context, deploymentID string) (*manifest, error) {
+	object, err := store.storage.open(ctx, manifestKey(deploymentID))
+	if err != nil {
+		return nil, err
+	}
+	defer object.body.Close()
+
+	fetched := &manifest{}
+	err = json.NewDecoder(object.body).Decode(fetched)
+	if err != nil {
+		return nil, err
+	}
+	// the artifact cache keeps the manifest once it is read to the end
+	io.Copy(io.Discard, object.body)
+
+	fetched.byPath = make(map[string]*manifestFile, len(fetched.Files))
+	fetched.ruleFiles = map[string]bool{}
+	for i := range fetched.Files {
+		fetched.Files[i].modified = fetched.CreatedAt
+		filePath := fetched.Files[i].Path
+		if siterules.Files[filePath] {
+			fetched.ruleFiles[filePath] = true
+			continue
+		}
+		fetched.byPath[filePath] = &fetched.Files[i]
+	}
+
+	return fetched, nil
+}
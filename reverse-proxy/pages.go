@@ -0,0 +1,83 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// platform pages for requests which can't be answered by a deployment
+var pageTemplate = template.Must(template.New("page").Parse(`<!doctype html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Title}} | Scale Mesh</title>
+    <style>
+      body { font-family: system-ui, sans-serif; background: #cff4fc; color: #055160; display: flex; min-height: 100vh; margin: 0; align-items: center; justify-content: center; }
+      main { text-align: center; padding: 2rem; }
+      h1 { font-size: 3rem; margin: 0 0 .5rem; }
+      p { font-size: 1.2rem; }
+      form { display: flex; gap: .5rem; justify-content: center; }
+      input, button { font-size: 1rem; padding: .5rem .75rem; border: 1px solid #055160; border-radius: .25rem; }
+      button { background: #055160; color: #cff4fc; cursor: pointer; }
+      footer { margin-top: 2rem; font-size: .9rem; opacity: .7; }
+    </style>
+  </head>
+  <body>
+    <main>
+      <h1>{{.Status}}</h1>
+      <h2>{{.Title}}</h2>
+      <p>{{.Message}}</p>
+      {{if .Login}}
+      <form method="post" action="/__scale-mesh/login">
+        <input type="hidden" name="redirect" value="{{.Redirect}}">
+        <input type="password" name="password" placeholder="Password" autofocus required>
+        <button type="submit">Continue</button>
+      </form>
+      {{end}}
+      <footer>Served by Scale Mesh</footer>
+    </main>
+  </body>
+</html>
+`))
+
+type page struct {
+	Status  int
+	Title   string
+	Message string
+	// the password form of a protected site, posting back to Redirect
+	Login    bool
+	Redirect string
+}
+
+func renderPage(w http.ResponseWriter, status int, title, message string) {
+	writePage(w, page{Status: status, Title: title, Message: message})
+}
+
+func writePage(w http.ResponseWriter, content page) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(content.Status)
+
+	err := pageTemplate.Execute(w, content)
+	if err != nil {
+		log.Println("ERROR: rendering the page", err)
+	}
+}
+
+// the host isn't attached to any project, or the project has nothing deployed yet
+func siteNotFound(w http.ResponseWriter, request *http.Request) {
+	renderPage(w, http.StatusNotFound, "Site not found", "There is no site deployed at "+requestHost(request)+".")
+}
+
+// the site is protected with a password, redirect is where the visitor goes once logged in
+func loginPage(w http.ResponseWriter, redirect, message string) {
+	writePage(w, page{
+		Status:   http.StatusUnauthorized,
+		Title:    "Password required",
+		Message:  message,
+		Login:    true,
+		Redirect: redirect,
+	})
+}
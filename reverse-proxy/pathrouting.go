@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// responses larger than this are passed through without rewriting their URLs
+const maxRewriteSize = 8 << 20
+
+// splitSitePath splits /{label}/rest of the path-based routing, rest keeps its leading slash
+// and is empty for /{label} itself
+func splitSitePath(urlPath string) (label, rest string) {
+	label, rest, found := strings.Cut(strings.TrimPrefix(urlPath, "/"), "/")
+	if !found {
+		return strings.ToLower(label), ""
+	}
+	return strings.ToLower(label), "/" + rest
+}
+
+type sitePrefixKey struct{}
+
+// stripSitePrefix is the request as the site sees it, without the /{label} of the path-based routing.
+// The prefix is kept in its context
+func stripSitePrefix(request *http.Request, prefix, rest string) *http.Request {
+	stripped := request.Clone(context.WithValue(request.Context(), sitePrefixKey{}, prefix))
+	stripped.URL.Path = rest
+	stripped.URL.RawPath = ""
+	return stripped
+}
+
+// sitePrefix is the /{label} the site is served under, empty on its own host
+func sitePrefix(request *http.Request) string {
+	prefix, _ := request.Context().Value(sitePrefixKey{}).(string)
+	return prefix
+}
+
+// rewritableType reports the documents whose URLs are moved under the prefix
+func rewritableType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/html" || mediaType == "text/css"
+}
+
+// the responses of an app or a proxied origin are asked whole under a prefix, their documents
+// can't be rewritten in ranges
+func dropRangesUnderPrefix(proxyRequest *httputil.ProxyRequest) {
+	if sitePrefix(proxyRequest.In) != "" {
+		proxyRequest.Out.Header.Del("Range")
+		proxyRequest.Out.Header.Del("If-Range")
+	}
+}
+
+// sharedOriginRoute is the route of a site under a prefix, where all the sites share the origin of the
+// base domain: the scripts of one site can read the others, so protected sites are only served on their
+// own host, and the header overrides of a site would apply to the whole origin
+func sharedOriginRoute(w http.ResponseWriter, projectRoute *route) (*route, bool) {
+	if projectRoute.Access != nil {
+		renderPage(w, http.StatusForbidden, "Access denied", "This site is protected, it is only available on its own host.")
+		return nil, false
+	}
+	if projectRoute.Headers == nil {
+		return projectRoute, true
+	}
+
+	shared := *projectRoute
+	shared.Headers = nil
+	return &shared, true
+}
+
+// root relative URLs in the attributes of HTML and in CSS url(), protocol relative //host URLs are left alone
+var (
+	htmlRootURL = regexp.MustCompile(`(?i)(\s(?:href|src|action|formaction|poster)\s*=\s*["']?)/([^/]|$)`)
+	cssRootURL  = regexp.MustCompile(`(?i)(url\(\s*["']?)/([^/]|$)`)
+)
+
+// rewriteRootURLs moves the root relative URLs of a document under the prefix of its site
+func rewriteRootURLs(body []byte, contentType, prefix string) []byte {
+	replacement := []byte("${1}" + prefix + "/${2}")
+	if contentType == "text/html" {
+		body = htmlRootURL.ReplaceAll(body, replacement)
+	}
+	return cssRootURL.ReplaceAll(body, replacement)
+}
+
+// prefixedResponse serves a site under /{label} on a base domain: redirects and cookies are moved
+// under the prefix, and so are the root relative URLs of the HTML and CSS files
+type prefixedResponse struct {
+	http.ResponseWriter
+	request     *http.Request
+	prefix      string
+	wroteHeader bool
+	status      int
+	// the body is held back until the whole document is rewritten
+	rewriting   bool
+	contentType string
+	buffer      bytes.Buffer
+}
+
+func (w *prefixedResponse) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+
+	header := w.Header()
+	if location := header.Get("Location"); strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") && !strings.HasPrefix(location, "/\\") {
+		header.Set("Location", w.prefix+location)
+	}
+	if cookies := header.Values("Set-Cookie"); len(cookies) > 0 {
+		header.Del("Set-Cookie")
+		for _, value := range cookies {
+			header.Add("Set-Cookie", w.cookieUnderPrefix(value))
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	rewritable := rewritableType(mediaType) && header.Get("Content-Encoding") == ""
+	if rewritableType(mediaType) {
+		// a range of the rewritten document wouldn't match the original bytes
+		header.Del("Accept-Ranges")
+	}
+	// error pages like 404.html link their assets too, partial and empty responses are left alone
+	hasDocument := status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusPartialContent && status != http.StatusNotModified
+	if rewritable && hasDocument {
+		// the length changes with the rewrite
+		header.Del("Content-Length")
+		if w.request.Method != http.MethodHead {
+			w.rewriting = true
+			w.contentType = mediaType
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *prefixedResponse) cookieUnderPrefix(value string) string {
+	cookie, err := http.ParseSetCookie(value)
+	if err != nil {
+		return value
+	}
+	if cookie.Path == "" || cookie.Path == "/" {
+		cookie.Path = w.prefix
+	} else if strings.HasPrefix(cookie.Path, "/") {
+		cookie.Path = w.prefix + cookie.Path
+	}
+	return cookie.String()
+}
+
+func (w *prefixedResponse) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if !w.rewriting {
+		return w.ResponseWriter.Write(p)
+	}
+
+	if w.buffer.Len()+len(p) > maxRewriteSize {
+		// too large to hold, pass the rest through as it is
+		w.rewriting = false
+		w.ResponseWriter.WriteHeader(w.status)
+		_, err := w.ResponseWriter.Write(w.buffer.Bytes())
+		w.buffer.Reset()
+		if err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buffer.Write(p)
+}
+
+// ReadFrom keeps the sendfile path for the files which aren't rewritten
+func (w *prefixedResponse) ReadFrom(src io.Reader) (int64, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.rewriting {
+		return io.Copy(writerOnly{w}, src)
+	}
+	if readerFrom, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return readerFrom.ReadFrom(src)
+	}
+	return io.Copy(w.ResponseWriter, src)
+}
+
+// writerOnly hides ReadFrom so io.Copy doesn't call back into it
+type writerOnly struct {
+	io.Writer
+}
+
+// Flush waits for the end of a document being rewritten
+func (w *prefixedResponse) Flush() {
+	if w.rewriting {
+		return
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *prefixedResponse) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sends the rewritten document, once the handler returned
+func (w *prefixedResponse) finish() {
+	if !w.rewriting {
+		return
+	}
+	w.rewriting = false
+
+	body := rewriteRootURLs(w.buffer.Bytes(), w.contentType, w.prefix)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(body)
+	if err != nil {
+		log.Println("ERROR: streaming the rewritten document", w.request.URL.Path, err)
+	}
+}
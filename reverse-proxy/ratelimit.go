@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// limiter takes a token from the bucket of key, which refills rate tokens per second up to burst.
+// When the bucket is empty it returns false and how long until the next token
+type limiter interface {
+	allow(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// localLimiter keeps the buckets in the memory of this proxy instance
+type localLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newLocalLimiter() *localLimiter {
+	limiter := &localLimiter{buckets: map[string]*bucket{}}
+	go limiter.sweep(time.Minute)
+	return limiter
+}
+
+func (limiter *localLimiter) allow(_ context.Context, key string, rate float64, burst int) (bool, time.Duration) {
+	return limiter.allowAt(key, rate, burst, time.Now())
+}
+
+func (limiter *localLimiter) allowAt(key string, rate float64, burst int, now time.Time) (bool, time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	current, ok := limiter.buckets[key]
+	if !ok {
+		current = &bucket{tokens: float64(burst), last: now}
+		limiter.buckets[key] = current
+	}
+
+	current.tokens = math.Min(float64(burst), current.tokens+now.Sub(current.last).Seconds()*rate)
+	current.last = now
+	if current.tokens >= 1 {
+		current.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - current.tokens) / rate * float64(time.Second))
+}
+
+// sweep drops the buckets which have been idle for the interval, they would be full again anyway
+func (limiter *localLimiter) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		limiter.mu.Lock()
+		for key, idle := range limiter.buckets {
+			if time.Since(idle.last) > interval {
+				delete(limiter.buckets, key)
+			}
+		}
+		limiter.mu.Unlock()
+	}
+}
+
+// the token bucket as one atomic step in redis, the time comes from the redis server
+// so the proxy instances don't depend on their clocks
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = redis.call("TIME")
+now = tonumber(now[1]) + tonumber(now[2]) / 1000000
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = (1 - tokens) / rate
+end
+
+redis.call("HSET", KEYS[1], "tokens", tokens, "last", now)
+redis.call("EXPIRE", KEYS[1], math.ceil(burst / rate) + 1)
+return {allowed, tostring(wait)}
+`)
+
+// redisLimiter shares the buckets between the proxy instances,
+// the local buckets take over while redis is unreachable
+type redisLimiter struct {
+	client   *redis.Client
+	fallback *localLimiter
+	// set while limiting locally, the outage is logged when it starts and when it ends
+	degraded atomic.Bool
+}
+
+func (limiter *redisLimiter) allow(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	result, err := tokenBucketScript.Run(ctx, limiter.client, []string{"ratelimit:" + key}, rate, burst).Slice()
+	if err != nil || len(result) != 2 {
+		if limiter.degraded.CompareAndSwap(false, true) {
+			log.Println("ERROR: unable to use the shared rate limits, limiting locally", err)
+		}
+		return limiter.fallback.allow(ctx, key, rate, burst)
+	}
+	if limiter.degraded.CompareAndSwap(true, false) {
+		log.Println("INFO: the shared rate limits are back")
+	}
+
+	allowed, _ := result[0].(int64)
+	wait, _ := strconv.ParseFloat(fmt.Sprint(result[1]), 64)
+
+	return allowed == 1, time.Duration(wait * float64(time.Second))
+}
+
+type limitStats struct {
+	rateLimited   atomic.Int64
+	tooLarge      atomic.Int64
+	tooConcurrent atomic.Int64
+}
+
+// requestLimits protects the tenants behind the proxy from each other
+type requestLimits struct {
+	limiter limiter
+
+	maxBodySize     int64
+	maxConcurrent   int64
+	maxConcurrentIP int64
+	concurrent      atomic.Int64
+	concurrentMu    sync.Mutex
+	concurrentPerIP map[string]int64
+	stats           limitStats
+}
+
+var limits *requestLimits
+
+func newRequestLimits(limiter limiter, maxBodySize, maxConcurrent, maxConcurrentIP int64) *requestLimits {
+	return &requestLimits{
+		limiter:         limiter,
+		maxBodySize:     maxBodySize,
+		maxConcurrent:   maxConcurrent,
+		maxConcurrentIP: maxConcurrentIP,
+		concurrentPerIP: map[string]int64{},
+	}
+}
+
+// acquire a slot for a request of the client, release must be called once it is answered
+func (limits *requestLimits) acquire(ip string) (release func(), ok bool) {
+	if inFlight := limits.concurrent.Add(1); limits.maxConcurrent > 0 && inFlight > limits.maxConcurrent {
+		limits.concurrent.Add(-1)
+		return nil, false
+	}
+
+	limits.concurrentMu.Lock()
+	if limits.maxConcurrentIP > 0 && limits.concurrentPerIP[ip] >= limits.maxConcurrentIP {
+		limits.concurrentMu.Unlock()
+		limits.concurrent.Add(-1)
+		return nil, false
+	}
+	limits.concurrentPerIP[ip]++
+	limits.concurrentMu.Unlock()
+
+	return func() {
+		limits.concurrent.Add(-1)
+		limits.concurrentMu.Lock()
+		limits.concurrentPerIP[ip]--
+		if limits.concurrentPerIP[ip] <= 0 {
+			delete(limits.concurrentPerIP, ip)
+		}
+		limits.concurrentMu.Unlock()
+	}, true
+}
+
+// withRequestLimits caps the concurrent requests overall and per client IP, and the request body size
+func (limits *requestLimits) withRequestLimits(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		release, ok := limits.acquire(clientIP(request))
+		if !ok {
+			limits.stats.tooConcurrent.Add(1)
+			tooManyRequests(w, time.Second)
+			return
+		}
+		defer release()
+
+		if limits.maxBodySize > 0 {
+			if request.ContentLength > limits.maxBodySize {
+				limits.stats.tooLarge.Add(1)
+				renderPage(w, http.StatusRequestEntityTooLarge, "Request too large", "The request body is larger than this site accepts.")
+				return
+			}
+			request.Body = http.MaxBytesReader(w, request.Body, limits.maxBodySize)
+		}
+
+		next.ServeHTTP(w, request)
+	})
+}
+
+// allowRequest applies the rate limits of the site, per host and per client IP. The project
+// overrides the defaults when its route has limits of its own. It returns false when
+// the request was answered with 429
+func (limits *requestLimits) allowRequest(w http.ResponseWriter, request *http.Request, projectRoute *route) bool {
+	hostRate, hostBurst := config.rateHost, config.rateHostBurst
+	if projectRoute.RateLimit > 0 {
+		hostRate, hostBurst = float64(projectRoute.RateLimit), 2*projectRoute.RateLimit
+	}
+	ipRate, ipBurst := config.rateIP, config.rateIPBurst
+	if projectRoute.RateLimitPerIP > 0 {
+		ipRate, ipBurst = float64(projectRoute.RateLimitPerIP), 2*projectRoute.RateLimitPerIP
+	}
+
+	project := strconv.FormatUint(uint64(projectRoute.ProjectID), 10)
+	if ipRate > 0 {
+		ok, retryAfter := limits.limiter.allow(request.Context(), "ip:"+project+":"+clientIP(request), ipRate, max(ipBurst, 1))
+		if !ok {
+			limits.stats.rateLimited.Add(1)
+			tooManyRequests(w, retryAfter)
+			return false
+		}
+	}
+	if hostRate > 0 {
+		ok, retryAfter := limits.limiter.allow(request.Context(), "host:"+project, hostRate, max(hostBurst, 1))
+		if !ok {
+			limits.stats.rateLimited.Add(1)
+			tooManyRequests(w, retryAfter)
+			return false
+		}
+	}
+
+	return true
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	renderPage(w, http.StatusTooManyRequests, "Too many requests", "This site is receiving too many requests, try again in a moment.")
+}
+
+func (limits *requestLimits) writeMetrics(w http.ResponseWriter) {
+	fmt.Fprintln(w, "# TYPE proxy_requests_rejected_total counter")
+	fmt.Fprintf(w, "proxy_requests_rejected_total{reason=\"rate_limit\"} %d\n", limits.stats.rateLimited.Load())
+	fmt.Fprintf(w, "proxy_requests_rejected_total{reason=\"body_size\"} %d\n", limits.stats.tooLarge.Load())
+	fmt.Fprintf(w, "proxy_requests_rejected_total{reason=\"concurrency\"} %d\n", limits.stats.tooConcurrent.Load())
+	fmt.Fprintln(w, "# TYPE proxy_requests_in_flight gauge")
+	fmt.Fprintf(w, "proxy_requests_in_flight %d\n", limits.concurrent.Load())
+}
+
+// metricsHandler exposes the counters of the proxy in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, request *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	cache.writeMetrics(w)
+	artifacts.breaker.writeMetrics(w)
+	limits.writeMetrics(w)
+}
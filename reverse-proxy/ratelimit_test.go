@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalLimiterAllow(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// 2 tokens per second up to a burst of 3, steps are applied in order on the same limiter
+	tests := []struct {
+		key     string
+		at      time.Duration
+		allowed bool
+		wait    time.Duration
+	}{
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, false, 500 * time.Millisecond},
+		{"b", 0, true, 0},
+		{"a", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"a", 500 * time.Millisecond, true, 0},
+		{"a", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		{"a", time.Second, true, 0},
+		// an idle bucket refills up to the burst only
+		{"a", time.Minute, true, 0},
+		{"a", time.Minute, true, 0},
+		{"a", time.Minute, true, 0},
+		{"a", time.Minute, false, 500 * time.Millisecond},
+	}
+
+	limiter := &localLimiter{buckets: map[string]*bucket{}}
+	for i, test := range tests {
+		allowed, wait := limiter.allowAt(test.key, 2, 3, start.Add(test.at))
+		if allowed != test.allowed || wait.Round(time.Millisecond) != test.wait {
+			t.Errorf("step %d: allow(%q) at %s = %v, %s, want %v, %s", i, test.key, test.at, allowed, wait, test.allowed, test.wait)
+		}
+	}
+}
+
+func TestLocalLimiterSlowRate(t *testing.T) {
+	limiter := &localLimiter{buckets: map[string]*bucket{}}
+
+	allowed, _ := limiter.allow(context.Background(), "slow", 0.1, 1)
+	if !allowed {
+		t.Fatal("the first request of a bucket was limited")
+	}
+	allowed, wait := limiter.allow(context.Background(), "slow", 0.1, 1)
+	if allowed || wait <= 9*time.Second || wait > 10*time.Second {
+		t.Errorf("allow() of an empty bucket = %v, %s, want a wait of about 10s", allowed, wait)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// resolution is the outcome of mapping a request path to a file of the deployment
+type resolution struct {
+	file     *manifestFile
+	status   int
+	redirect string // set for a redirect instead of a file
+}
+
+// resolve a URL path against the manifest with the rules of the project:
+//   - the exact file, or index.html of a directory requested with a trailing slash
+//   - a directory requested without the trailing slash is redirected to it
+//   - with clean URLs /about is served from about.html or about/index.html
+//   - with the SPA fallback paths without a file extension are served from index.html
+//   - otherwise 404.html of the deployment with status 404, if there is one
+func resolve(deploymentManifest *manifest, projectRoute *route, urlPath string) resolution {
+	filePath := requestFilePath(urlPath)
+	if file, ok := deploymentManifest.file(filePath); ok {
+		return resolution{file: file, status: http.StatusOK}
+	}
+
+	if !strings.HasSuffix(urlPath, "/") && filePath != "" {
+		if projectRoute.CleanURLs {
+			for _, candidate := range []string{filePath + ".html", filePath + "/index.html"} {
+				if file, ok := deploymentManifest.file(candidate); ok {
+					return resolution{file: file, status: http.StatusOK}
+				}
+			}
+		} else if _, ok := deploymentManifest.file(filePath + "/index.html"); ok {
+			return resolution{status: http.StatusMovedPermanently, redirect: "/" + filePath + "/"}
+		}
+	}
+
+	if projectRoute.SPAFallback && path.Ext(filePath) == "" {
+		if file, ok := deploymentManifest.file("index.html"); ok {
+			return resolution{file: file, status: http.StatusOK}
+		}
+	}
+
+	if file, ok := deploymentManifest.file("404.html"); ok {
+		return resolution{file: file, status: http.StatusNotFound}
+	}
+
+	return resolution{status: http.StatusNotFound}
+}
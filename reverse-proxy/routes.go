@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errNoRoute = errors.New("no live deployment for the host")
+
+// route tells which deployment serves a project and how paths are resolved
+type route struct {
+	ProjectID    uint   `json:"projectId"`
+	Slug         string `json:"slug"`
+	DeploymentID uint   `json:"deploymentId"`
+	SPAFallback  bool   `json:"spaFallback"`
+	CleanURLs    bool   `json:"cleanUrls"`
+	// nil for a public site
+	Access *siteAccess `json:"access"`
+	// requests per second overriding the defaults of the proxy, 0 keeps them
+	RateLimit      int `json:"rateLimit"`
+	RateLimitPerIP int `json:"rateLimitPerIp"`
+	// nil unless a canary deployment is served to a share of the visitors
+	Canary *canaryRoute `json:"canary"`
+	// the route of a single deployment on its preview host
+	Preview bool `json:"preview"`
+	// nil keeps the security headers of the proxy
+	Headers *securityHeaders `json:"headers"`
+	// origins allowed to read the site with CORS, * for any
+	CORSOrigins []string `json:"corsOrigins"`
+	// a site in maintenance or suspended is served a 503 page
+	Maintenance *maintenanceRoute `json:"maintenance"`
+	Suspension  *suspensionRoute  `json:"suspension"`
+	// "app" for a deployment run by the app runner, empty for a static site
+	Runtime string `json:"runtime"`
+}
+
+func (r *route) deploymentKey() string {
+	return strconv.FormatUint(uint64(r.DeploymentID), 10)
+}
+
+// unknown hosts cached at most, beyond it the expired ones are swept and then all of them dropped
+const maxMissingRoutes = 10000
+
+type cachedRoute struct {
+	route     *route
+	err       error
+	expiresAt time.Time
+}
+
+// routeTable looks up routes from the api server and caches them for ttl,
+// missing routes are cached as well so unknown hosts don't hit the api on every request
+type routeTable struct {
+	apiURL string
+	token  string
+	ttl    time.Duration
+	client *http.Client
+
+	mu      sync.Mutex
+	entries map[string]cachedRoute
+	missing int // entries without a route
+}
+
+func newRouteTable(apiURL, token string, ttl time.Duration) *routeTable {
+	return &routeTable{
+		apiURL:  apiURL,
+		token:   token,
+		ttl:     ttl,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		entries: map[string]cachedRoute{},
+	}
+}
+
+// api server paths of the routes, they are the keys of the table
+func projectPath(projectID string) string {
+	return "/internal/projects/" + url.PathEscape(projectID) + "/route"
+}
+
+func slugPath(slug string) string {
+	return "/internal/slugs/" + url.PathEscape(slug) + "/route"
+}
+
+func previewPath(deploymentID, slug string) string {
+	return "/internal/previews/" + url.PathEscape(slug) + "/" + url.PathEscape(deploymentID) + "/route"
+}
+
+func hostPath(hostname string) string {
+	return "/internal/hosts/" + url.PathEscape(hostname) + "/route"
+}
+
+// lookupProject finds the route of a {projectID}.{baseDomain} host, the address of a site before slugs
+func (table *routeTable) lookupProject(projectID string) (*route, error) {
+	return table.lookup(projectPath(projectID))
+}
+
+// lookupSlug finds the route of a {slug}.{baseDomain} host
+func (table *routeTable) lookupSlug(slug string) (*route, error) {
+	return table.lookup(slugPath(slug))
+}
+
+// lookupPreview finds the route of a {deploymentID}--{slug}.{baseDomain} host
+func (table *routeTable) lookupPreview(deploymentID, slug string) (*route, error) {
+	return table.lookup(previewPath(deploymentID, slug))
+}
+
+// lookupHost finds the route of a custom domain attached to a project
+func (table *routeTable) lookupHost(hostname string) (*route, error) {
+	return table.lookup(hostPath(hostname))
+}
+
+// lookup the route from the api server path, the path is the cache key
+func (table *routeTable) lookup(path string) (*route, error) {
+	table.mu.Lock()
+	entry, ok := table.entries[path]
+	table.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.route, entry.err
+	}
+
+	found, err := table.fetch(path)
+	if err != nil && err != errNoRoute {
+		// keep serving the last known route while the api server is unreachable
+		if ok && entry.route != nil {
+			return entry.route, nil
+		}
+		return nil, err
+	}
+
+	table.mu.Lock()
+	table.put(path, cachedRoute{route: found, err: err, expiresAt: time.Now().Add(table.ttl)})
+	table.mu.Unlock()
+
+	return found, err
+}
+
+// put caches the entry of the path, the caller holds the lock
+func (table *routeTable) put(path string, entry cachedRoute) {
+	table.remove(path)
+	if entry.route == nil {
+		if table.missing >= maxMissingRoutes {
+			table.evictMissing()
+		}
+		table.missing++
+	}
+	table.entries[path] = entry
+}
+
+// remove the entry of the path, the caller holds the lock
+func (table *routeTable) remove(path string) {
+	if old, ok := table.entries[path]; ok {
+		if old.route == nil {
+			table.missing--
+		}
+		delete(table.entries, path)
+	}
+}
+
+// evictMissing drops the expired entries without a route, or all of them when a flood of
+// unknown hosts keeps them from expiring, the caller holds the lock
+func (table *routeTable) evictMissing() {
+	now := time.Now()
+	for path, entry := range table.entries {
+		if entry.route == nil && now.After(entry.expiresAt) {
+			table.remove(path)
+		}
+	}
+	if table.missing < maxMissingRoutes/2 {
+		return
+	}
+	for path, entry := range table.entries {
+		if entry.route == nil {
+			table.remove(path)
+		}
+	}
+}
+
+func (table *routeTable) fetch(path string) (*route, error) {
+	found := &route{}
+	err := table.getJSON(path, found)
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// getJSON decodes the response of the api server path, a 404 is errNoRoute
+func (table *routeTable) getJSON(path string, into any) error {
+	request, err := http.NewRequest(http.MethodGet, table.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-Internal-Token", table.token)
+
+	response, err := table.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return errNoRoute
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("api server responded with %s", response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(into)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"gitlab.com/harisheoran/scale-mesh/siterules"
+)
+
+// siteRules loads and compiles the rule files of a deployment once, the compiled rules
+// are cached with its manifest
+func (store *manifestStore) siteRules(ctx context.Context, deploymentID string, deploymentManifest *manifest) (*siterules.Rules, error) {
+	deploymentManifest.rulesMu.Lock()
+	cached := deploymentManifest.rules
+	deploymentManifest.rulesMu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	// the files are read without the lock, concurrent first requests may both load them
+	compiled, err := store.loadRules(ctx, deploymentID, deploymentManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	deploymentManifest.rulesMu.Lock()
+	defer deploymentManifest.rulesMu.Unlock()
+	if deploymentManifest.rules == nil {
+		deploymentManifest.rules = compiled
+	}
+	return deploymentManifest.rules, nil
+}
+
+func (store *manifestStore) loadRules(ctx context.Context, deploymentID string, deploymentManifest *manifest) (*siterules.Rules, error) {
+	compiled := &siterules.Rules{}
+	if deploymentManifest.ruleFiles["_redirects"] {
+		object, err := store.storage.open(ctx, artifactKey(deploymentID, "_redirects"))
+		if err != nil {
+			return nil, err
+		}
+		var errs []error
+		compiled.Redirects, errs = siterules.ParseRedirects(object.body)
+		object.body.Close()
+		for _, err := range errs {
+			log.Printf("ERROR: deployment %s: %s", deploymentID, err)
+		}
+	}
+	if deploymentManifest.ruleFiles["_headers"] {
+		object, err := store.storage.open(ctx, artifactKey(deploymentID, "_headers"))
+		if err != nil {
+			return nil, err
+		}
+		var errs []error
+		compiled.Headers, errs = siterules.ParseHeaders(object.body)
+		object.body.Close()
+		for _, err := range errs {
+			log.Printf("ERROR: deployment %s: %s", deploymentID, err)
+		}
+	}
+
+	return compiled, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errArtifactNotFound = errors.New("artifact not found in the storage")
+
+// artifactStore reads the build artifacts through the public URL of the bucket,
+// objects are stored as __output/{deploymentID}/{path}
+type artifactStore struct {
+	baseURL string
+	client  *http.Client
+	breaker *circuitBreaker
+}
+
+// timeout bounds the wait for the storage to start answering, the body may take longer
+func newArtifactStore(baseURL string, timeout time.Duration, breaker *circuitBreaker) *artifactStore {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+	// the .gz and .br variants are stored with their Content-Encoding and served as they are,
+	// the transport must not ask for gzip and decompress them
+	transport.DisableCompression = true
+
+	return &artifactStore{
+		baseURL: baseURL,
+		breaker: breaker,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   60 * time.Second,
+			// the storage must answer itself, never follow it somewhere else
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// artifact is an open object of the storage, the caller must close the body
+type artifact struct {
+	body   io.ReadCloser
+	size   int64
+	header http.Header
+}
+
+// open the object at key, a key is relative to the bucket root
+func (store *artifactStore) open(ctx context.Context, key string) (*artifact, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, store.baseURL+"/"+escapeKey(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !store.breaker.allow() {
+		return nil, errStorageUnavailable
+	}
+
+	response, err := store.client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			store.breaker.abandon()
+		} else {
+			store.breaker.failure()
+		}
+		return nil, err
+	}
+
+	// throttling counts as a failure, the storage needs a break as well
+	if response.StatusCode >= http.StatusInternalServerError || response.StatusCode == http.StatusTooManyRequests {
+		store.breaker.failure()
+	} else {
+		store.breaker.success()
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return &artifact{body: response.Body, size: response.ContentLength, header: response.Header}, nil
+	case http.StatusNotFound, http.StatusForbidden:
+		// a public bucket answers 403 for missing keys when listing isn't allowed
+		response.Body.Close()
+		return nil, errArtifactNotFound
+	default:
+		response.Body.Close()
+		return nil, fmt.Errorf("storage responded with %s for %s", response.Status, key)
+	}
+}
+
+// escapeKey escapes every segment of key for the URL path, file names may hold any character
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func artifactKey(deploymentID, filePath string) string {
+	return "__output/" + deploymentID + "/" + filePath
+}
+
+func manifestKey(deploymentID string) string {
+	return "__output/" + deploymentID + ".manifest.json"
+}
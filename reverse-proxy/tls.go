@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certStore picks the certificate of a TLS handshake by its SNI hostname,
+// from the static certificates first and then from ACME for verified custom domains
+type certStore struct {
+	mu sync.RWMutex
+	// static certificates by the names they are valid for, wildcards as "*.example.com"
+	names    map[string]*tls.Certificate
+	fallback *tls.Certificate
+
+	acme *autocert.Manager
+}
+
+func newCertStore() *certStore {
+	return &certStore{names: map[string]*tls.Certificate{}}
+}
+
+// load a certificate and key pair, it serves the names in its subject alternative names
+func (store *certStore) load(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, name := range leaf.DNSNames {
+		store.names[strings.ToLower(name)] = &cert
+	}
+	if store.fallback == nil {
+		store.fallback = &cert
+	}
+	if time.Now().After(leaf.NotAfter) {
+		log.Println("WARNING: the certificate", certFile, "expired on", leaf.NotAfter)
+	}
+
+	return nil
+}
+
+// static certificate of the hostname, an exact name wins over a wildcard
+func (store *certStore) static(hostname string) *tls.Certificate {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if cert, ok := store.names[hostname]; ok {
+		return cert
+	}
+	if _, parent, ok := strings.Cut(hostname, "."); ok {
+		if cert, ok := store.names["*."+parent]; ok {
+			return cert
+		}
+	}
+
+	return nil
+}
+
+func (store *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	hostname := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+
+	// the TLS-ALPN-01 challenge has to be answered by the ACME manager
+	if store.acme != nil && isACMEChallenge(hello) {
+		return store.acme.GetCertificate(hello)
+	}
+
+	if cert := store.static(hostname); cert != nil {
+		return cert, nil
+	}
+	if store.acme != nil && hostname != "" && !underBaseDomain(hostname) {
+		return store.acme.GetCertificate(hello)
+	}
+	if store.fallback != nil {
+		return store.fallback, nil
+	}
+
+	return nil, fmt.Errorf("no certificate for %q", hostname)
+}
+
+func isACMEChallenge(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+// newACMEManager issues certificates from the ACME directory, caches them in cacheDir
+// and renews them in the background renewBefore their expiry
+func newACMEManager(directoryURL, email, cacheDir, caFile string, renewBefore time.Duration) (*autocert.Manager, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// a local ACME server like Pebble serves its directory with a certificate of its own CA
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	client := &acme.Client{
+		DirectoryURL: directoryURL,
+		HTTPClient:   &http.Client{Transport: &orderLocations{next: transport, orders: map[string]string{}}},
+	}
+
+	return &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(cacheDir),
+		HostPolicy:  verifiedDomainPolicy,
+		RenewBefore: renewBefore,
+		Client:      client,
+		Email:       email,
+	}, nil
+}
+
+// orderLocations adds the order URL to finalize responses which don't carry it.
+// The ACME client polls a processing order through the Location header of the finalize
+// response, which RFC 8555 doesn't require, so servers like Pebble leave it out.
+type orderLocations struct {
+	next http.RoundTripper
+
+	mu     sync.Mutex
+	orders map[string]string // order URL by its finalize URL
+}
+
+func (transport *orderLocations) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := transport.next.RoundTrip(request)
+	if err != nil || request.Method != http.MethodPost || response.StatusCode >= 300 {
+		return response, err
+	}
+
+	requestURL := request.URL.String()
+	location := response.Header.Get("Location")
+	if location == "" {
+		transport.mu.Lock()
+		orderURL, ok := transport.orders[requestURL]
+		delete(transport.orders, requestURL)
+		transport.mu.Unlock()
+		if ok {
+			response.Header.Set("Location", orderURL)
+		}
+		return response, nil
+	}
+
+	// remember the finalize URL of new orders
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	var order struct {
+		Finalize string `json:"finalize"`
+	}
+	if json.Unmarshal(body, &order) == nil && order.Finalize != "" {
+		transport.mu.Lock()
+		transport.orders[order.Finalize] = location
+		transport.mu.Unlock()
+	}
+
+	return response, nil
+}
+
+// certificates are only issued for custom domains which are verified and routed to a project,
+// so nobody can make the proxy request certificates for arbitrary hosts
+func verifiedDomainPolicy(ctx context.Context, hostname string) error {
+	if underBaseDomain(hostname) {
+		return errors.New("hosts under the base domain use the static certificate")
+	}
+
+	_, err := routes.lookupHost(hostname)
+	if err == errNoRoute {
+		return fmt.Errorf("%s is not a verified custom domain", hostname)
+	}
+
+	return err
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the HTTPS listener
+func redirectToHTTPS(w http.ResponseWriter, request *http.Request) {
+	host := request.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if _, port, err := net.SplitHostPort(config.tlsAddress); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	// 308 keeps the method and the body of other requests than GET and HEAD
+	status := http.StatusMovedPermanently
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, request, "https://"+host+request.URL.RequestURI(), status)
+}
+
+// withHSTS tells browsers to only use HTTPS for the host for maxAge
+func withHSTS(next http.Handler, maxAge time.Duration, includeSubdomains bool) http.Handler {
+	if maxAge <= 0 {
+		return next
+	}
+
+	value := "max-age=" + strconv.FormatInt(int64(maxAge.Seconds()), 10)
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, request)
+	})
+}
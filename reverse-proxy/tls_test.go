@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// writeCert writes a self-signed certificate for names and its key to dir
+func writeCert(t *testing.T, dir string, names ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, names[0]+".pem")
+	keyFile := filepath.Join(dir, names[0]+".key")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	store := newCertStore()
+	for _, names := range [][]string{{"localhost", "*.localhost"}, {"example.com", "www.example.com"}} {
+		certFile, keyFile := writeCert(t, dir, names...)
+		err := store.load(certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"localhost", "localhost"},
+		{"my-site.localhost", "localhost"},
+		{"example.com", "example.com"},
+		{"WWW.Example.com.", "example.com"},
+		{"shop.example.com", "localhost"},
+		{"a.b.localhost", "localhost"},
+		{"unknown.org", "localhost"},
+		{"", "localhost"},
+	}
+
+	for _, test := range tests {
+		cert, err := store.getCertificate(&tls.ClientHelloInfo{ServerName: test.serverName})
+		if err != nil {
+			t.Errorf("getCertificate(%q) error %v", test.serverName, err)
+			continue
+		}
+		if got := cert.Leaf.Subject.CommonName; got != test.want {
+			t.Errorf("getCertificate(%q) = %s, want %s", test.serverName, got, test.want)
+		}
+	}
+
+	if _, err := newCertStore().getCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); err == nil {
+		t.Error("getCertificate() of an empty store didn't fail")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	defer func(address string) { config.tlsAddress = address }(config.tlsAddress)
+
+	tests := []struct {
+		tlsAddress string
+		method     string
+		target     string
+		wantStatus int
+		wantURL    string
+	}{
+		{":443", http.MethodGet, "http://example.com/a?b=c", http.StatusMovedPermanently, "https://example.com/a?b=c"},
+		{":443", http.MethodGet, "http://example.com:8080/", http.StatusMovedPermanently, "https://example.com/"},
+		{":8443", http.MethodHead, "http://my-site.localhost:8080/x", http.StatusMovedPermanently, "https://my-site.localhost:8443/x"},
+		{":8443", http.MethodPost, "http://example.com/form", http.StatusPermanentRedirect, "https://example.com:8443/form"},
+	}
+
+	for _, test := range tests {
+		config.tlsAddress = test.tlsAddress
+		recorder := httptest.NewRecorder()
+		redirectToHTTPS(recorder, httptest.NewRequest(test.method, test.target, nil))
+		if recorder.Code != test.wantStatus || recorder.Header().Get("Location") != test.wantURL {
+			t.Errorf("%s %s = %d %s, want %d %s", test.method, test.target, recorder.Code, recorder.Header().Get("Location"), test.wantStatus, test.wantURL)
+		}
+	}
+}
+
+func TestWithHSTS(t *testing.T) {
+	tests := []struct {
+		maxAge            time.Duration
+		includeSubdomains bool
+		want              string
+	}{
+		{0, true, ""},
+		{24 * time.Hour, false, "max-age=86400"},
+		{365 * 24 * time.Hour, true, "max-age=31536000; includeSubDomains"},
+	}
+
+	for _, test := range tests {
+		handler := withHSTS(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), test.maxAge, test.includeSubdomains)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+		if got := recorder.Header().Get("Strict-Transport-Security"); got != test.want {
+			t.Errorf("withHSTS(%s, %v) = %q, want %q", test.maxAge, test.includeSubdomains, got, test.want)
+		}
+	}
+}
+
+// TestACMEIssuance issues a certificate from a local Pebble started with PEBBLE_VA_ALWAYS_VALID=1,
+// e.g. PEBBLE_DIRECTORY=https://localhost:14000/dir PEBBLE_CA=test/certs/pebble.minica.pem
+func TestACMEIssuance(t *testing.T) {
+	directory := os.Getenv("PEBBLE_DIRECTORY")
+	if directory == "" {
+		t.Skip("PEBBLE_DIRECTORY is not set")
+	}
+
+	manager, err := newACMEManager(directory, "", t.TempDir(), os.Getenv("PEBBLE_CA"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the routes of the verified domains aren't loaded in the test
+	manager.HostPolicy = autocert.HostWhitelist("shop.example.com")
+
+	hello := &tls.ClientHelloInfo{
+		ServerName:   "shop.example.com",
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+	cert, err := manager.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf == nil || len(cert.Leaf.DNSNames) != 1 || cert.Leaf.DNSNames[0] != "shop.example.com" {
+		t.Errorf("issued certificate %+v, want one for shop.example.com", cert.Leaf)
+	}
+
+	// the cached certificate is served without another order
+	again, err := manager.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Errorf("second GetCertificate() = %v, want the cached certificate %v", again.Leaf.SerialNumber, cert.Leaf.SerialNumber)
+	}
+
+	_, err = manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com", CipherSuites: hello.CipherSuites})
+	if err == nil {
+		t.Error("GetCertificate() issued a certificate for a host outside the policy")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var errPrivateUpstream = errors.New("proxy rules can not target private addresses")
+
+// refuse to dial loopback, private and link local addresses so a _redirects proxy rule
+// can't reach the platform's internal network
+func refusePrivateAddresses(network, address string, _ syscall.RawConn) error {
+	if config.allowPrivateUpstreams {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return errPrivateUpstream
+	}
+
+	return nil
+}
+
+var upstreamTransport = &http.Transport{
+	Proxy: nil,
+	DialContext: (&net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: refusePrivateAddresses,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+}
+
+// proxy the request to the target URL of a _redirects rule, the query of the request
+// is kept unless the target has its own
+func proxyToURL(w http.ResponseWriter, request *http.Request, projectRoute *route, target string) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		log.Println("ERROR: invalid proxy target", target, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	if targetURL.RawQuery == "" {
+		targetURL.RawQuery = request.URL.RawQuery
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(proxyRequest *httputil.ProxyRequest) {
+			proxyRequest.Out.URL = targetURL
+			proxyRequest.Out.Host = targetURL.Host
+			proxyRequest.SetXForwarded()
+			stripAccessCredentials(proxyRequest.Out.Header, projectRoute)
+			dropRangesUnderPrefix(proxyRequest)
+		},
+		Transport:      upstreamTransport,
+		ModifyResponse: preferOriginHeaders(w),
+		ErrorHandler: func(w http.ResponseWriter, request *http.Request, err error) {
+			log.Println("ERROR: proxying to", targetURL, err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		},
+	}
+
+	proxy.ServeHTTP(w, request)
+}
+
+// preferOriginHeaders drops the security headers of the site which the proxied response sets itself
+func preferOriginHeaders(w http.ResponseWriter) func(*http.Response) error {
+	return func(response *http.Response) error {
+		for _, name := range siteHeaderNames {
+			if response.Header.Get(name) != "" {
+				w.Header().Del(name)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,3 @@
+module gitlab.com/harisheoran/scale-mesh/siterules
+
+go 1.23.1
@@ -0,0 +1,340 @@
+// Package siterules parses the _redirects and _headers files of a deployment. The build
+// server validates them with it before the upload and the reverse proxy applies them.
+package siterules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Files are the rule files, read from the deployment root and never served
+var Files = map[string]bool{
+	"_redirects": true,
+	"_headers":   true,
+}
+
+// RedirectRule is a line of the _redirects file: from [query conditions] to [status][!]
+type RedirectRule struct {
+	From   []string          // segments of the pattern, ":name" placeholders and a trailing "*" splat
+	Query  map[string]string // query parameter conditions, ":name" values capture a placeholder
+	To     string
+	Status int
+	Force  bool // apply even if a file exists at the path
+	Line   int
+}
+
+// proxied rules rewrite to another origin instead of a file of the deployment
+func (rule *RedirectRule) IsProxy() bool {
+	return rule.Status == http.StatusOK && (strings.HasPrefix(rule.To, "http://") || strings.HasPrefix(rule.To, "https://"))
+}
+
+// HeaderRule is a block of the _headers file: a path pattern followed by indented "Name: value" lines
+type HeaderRule struct {
+	From    []string
+	Headers http.Header
+	Line    int
+}
+
+// Rules are the compiled _redirects and _headers of a deployment
+type Rules struct {
+	Redirects []RedirectRule
+	Headers   []HeaderRule
+}
+
+// Error is a syntax error of a rule file
+type Error struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+var allowedRedirectStatus = map[int]bool{
+	http.StatusOK:                true,
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusSeeOther:          true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+	http.StatusNotFound:          true,
+	http.StatusGone:              true,
+}
+
+// ParseRedirects parses a _redirects file, invalid lines are skipped and returned as errors
+func ParseRedirects(reader io.Reader) ([]RedirectRule, []error) {
+	rules := []RedirectRule{}
+	errs := []error{}
+
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		rule, err := parseRedirectLine(strings.Fields(text), line)
+		if err != nil {
+			errs = append(errs, Error{File: "_redirects", Line: line, Msg: err.Error()})
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return rules, errs
+}
+
+func parseRedirectLine(fields []string, line int) (RedirectRule, error) {
+	rule := RedirectRule{Status: http.StatusMovedPermanently, Line: line, Query: map[string]string{}}
+
+	from, err := parsePattern(fields[0])
+	if err != nil {
+		return rule, err
+	}
+	rule.From = from
+	fields = fields[1:]
+
+	// query parameter conditions come between the pattern and the target
+	for len(fields) > 0 && !strings.HasPrefix(fields[0], "/") && strings.Contains(fields[0], "=") {
+		key, value, _ := strings.Cut(fields[0], "=")
+		if key == "" {
+			return rule, fmt.Errorf("invalid query condition %q", fields[0])
+		}
+		rule.Query[key] = value
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 {
+		return rule, fmt.Errorf("missing the target of %q", "/"+strings.Join(rule.From, "/"))
+	}
+	rule.To = fields[0]
+	if !strings.HasPrefix(rule.To, "/") && !strings.HasPrefix(rule.To, "http://") && !strings.HasPrefix(rule.To, "https://") {
+		return rule, fmt.Errorf("target %q must be a path or an http(s) URL", rule.To)
+	}
+	if strings.HasPrefix(rule.To, "http") {
+		if _, err := url.Parse(rule.To); err != nil {
+			return rule, fmt.Errorf("invalid target URL %q", rule.To)
+		}
+	}
+	fields = fields[1:]
+
+	if len(fields) > 0 {
+		statusField := fields[0]
+		if strings.HasSuffix(statusField, "!") {
+			rule.Force = true
+			statusField = strings.TrimSuffix(statusField, "!")
+		}
+		status, err := strconv.Atoi(statusField)
+		if err != nil || !allowedRedirectStatus[status] {
+			return rule, fmt.Errorf("invalid status %q", fields[0])
+		}
+		rule.Status = status
+		fields = fields[1:]
+	}
+
+	if len(fields) > 0 {
+		return rule, fmt.Errorf("unsupported condition %q", strings.Join(fields, " "))
+	}
+
+	return rule, nil
+}
+
+// ParseHeaders parses a _headers file, invalid lines are skipped and returned as errors
+func ParseHeaders(reader io.Reader) ([]HeaderRule, []error) {
+	rules := []HeaderRule{}
+	errs := []error{}
+
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	var current *HeaderRule
+	for scanner.Scan() {
+		line++
+		raw := scanner.Text()
+		text := strings.TrimSpace(raw)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// a line without indentation starts a new path block
+		if raw[0] != ' ' && raw[0] != '\t' {
+			from, err := parsePattern(text)
+			if err != nil {
+				errs = append(errs, Error{File: "_headers", Line: line, Msg: err.Error()})
+				current = nil
+				continue
+			}
+			rules = append(rules, HeaderRule{From: from, Headers: http.Header{}, Line: line})
+			current = &rules[len(rules)-1]
+			continue
+		}
+
+		if current == nil {
+			errs = append(errs, Error{File: "_headers", Line: line, Msg: "header without a path"})
+			continue
+		}
+
+		name, value, ok := strings.Cut(text, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			errs = append(errs, Error{File: "_headers", Line: line, Msg: fmt.Sprintf("invalid header %q, expected Name: value", text)})
+			continue
+		}
+		current.Headers.Add(name, strings.TrimSpace(value))
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return rules, errs
+}
+
+// parsePattern splits a path pattern into segments, "*" is only allowed as the last segment
+func parsePattern(pattern string) ([]string, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pattern)
+	}
+
+	segments := splitPath(pattern)
+	for i, segment := range segments {
+		if strings.Contains(segment, "*") && (segment != "*" || i != len(segments)-1) {
+			return nil, fmt.Errorf("path %q can only end with the * splat", pattern)
+		}
+		if segment == ":" {
+			return nil, fmt.Errorf("path %q has a placeholder without a name", pattern)
+		}
+	}
+
+	return segments, nil
+}
+
+// splitPath splits the path into its segments, empty segments are dropped so a splat never
+// starts with a slash
+func splitPath(urlPath string) []string {
+	segments := []string{}
+	for _, segment := range strings.Split(urlPath, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// matchPattern matches the path against the segments and returns the captured placeholders,
+// the splat is captured as "splat"
+func matchPattern(pattern []string, urlPath string) (map[string]string, bool) {
+	segments := splitPath(urlPath)
+	params := map[string]string{}
+
+	for i, part := range pattern {
+		if part == "*" {
+			if i < len(segments) {
+				params["splat"] = strings.Join(segments[i:], "/")
+			} else {
+				params["splat"] = ""
+			}
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if strings.HasPrefix(part, ":") {
+			params[part[1:]] = segments[i]
+			continue
+		}
+		if part != segments[i] {
+			return nil, false
+		}
+	}
+
+	return params, len(segments) == len(pattern)
+}
+
+// Match finds the first redirect rule for the request, rules which aren't forced are
+// shadowed by a file existing at the path
+func (rules *Rules) Match(urlPath string, query url.Values, fileExists bool) (*RedirectRule, string, bool) {
+	for i := range rules.Redirects {
+		rule := &rules.Redirects[i]
+		if fileExists && !rule.Force {
+			continue
+		}
+
+		params, ok := matchPattern(rule.From, urlPath)
+		if !ok {
+			continue
+		}
+
+		queryMatched := true
+		for key, expected := range rule.Query {
+			value := query.Get(key)
+			if !query.Has(key) || (!strings.HasPrefix(expected, ":") && value != expected) {
+				queryMatched = false
+				break
+			}
+			if strings.HasPrefix(expected, ":") {
+				params[expected[1:]] = value
+			}
+		}
+		if !queryMatched {
+			continue
+		}
+
+		// a placeholder must not turn a path of the site into a URL of another host
+		target := expandTarget(rule.To, params)
+		if strings.HasPrefix(rule.To, "/") && (strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\")) {
+			continue
+		}
+
+		return rule, target, true
+	}
+
+	return nil, "", false
+}
+
+// expandTarget replaces the :splat and :name placeholders of the target
+func expandTarget(target string, params map[string]string) string {
+	// longer names first so :id doesn't replace the start of :identifier
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	for i := 1; i < len(names); i++ {
+		for j := i; j > 0 && len(names[j]) > len(names[j-1]); j-- {
+			names[j], names[j-1] = names[j-1], names[j]
+		}
+	}
+
+	for _, name := range names {
+		target = strings.ReplaceAll(target, ":"+name, params[name])
+	}
+
+	return target
+}
+
+// HeadersFor collects the headers of every _headers block matching the path
+func (rules *Rules) HeadersFor(urlPath string) http.Header {
+	collected := http.Header{}
+	for _, rule := range rules.Headers {
+		if _, ok := matchPattern(rule.From, urlPath); !ok {
+			continue
+		}
+		for name, values := range rule.Headers {
+			for _, value := range values {
+				collected.Add(name, value)
+			}
+		}
+	}
+
+	return collected
+}
@@ -0,0 +1,140 @@
+package siterules
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseRedirects(t *testing.T) {
+	tests := []struct {
+		line   string
+		from   string
+		to     string
+		status int
+		force  bool
+		err    bool
+	}{
+		{"/old /new", "old", "/new", 301, false, false},
+		{"/blog/* /posts/:splat 302", "blog/*", "/posts/:splat", 302, false, false},
+		{"/* /index.html 200", "*", "/index.html", 200, false, false},
+		{"/api/* https://api.example.com/:splat 200!", "api/*", "https://api.example.com/:splat", 200, true, false},
+		{"/users/:id /u/:id", "users/:id", "/u/:id", 301, false, false},
+		{"/search q=:q /find/:q 302", "search", "/find/:q", 302, false, false},
+		{"old /new", "", "", 0, false, true},
+		{"/a/*/b /c", "", "", 0, false, true},
+		{"/a", "", "", 0, false, true},
+		{"/a new", "", "", 0, false, true},
+		{"/a /b 304", "", "", 0, false, true},
+		{"/a /b 301 Country=us", "", "", 0, false, true},
+		{"/: /b", "", "", 0, false, true},
+	}
+
+	for _, test := range tests {
+		rules, errs := ParseRedirects(strings.NewReader(test.line))
+		if test.err {
+			if len(errs) != 1 || len(rules) != 0 {
+				t.Errorf("ParseRedirects(%q) = %v, %v, want an error", test.line, rules, errs)
+			}
+			continue
+		}
+		if len(errs) != 0 || len(rules) != 1 {
+			t.Errorf("ParseRedirects(%q) = %v, %v, want a rule", test.line, rules, errs)
+			continue
+		}
+		rule := rules[0]
+		if strings.Join(rule.From, "/") != test.from || rule.To != test.to || rule.Status != test.status || rule.Force != test.force {
+			t.Errorf("ParseRedirects(%q) = %+v, want from %q to %q status %d force %v", test.line, rule, test.from, test.to, test.status, test.force)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	file := "# comment\n/assets/*\n  Cache-Control: public, max-age=31536000\n  X-Robots-Tag: noindex\n/*\n\tX-Frame-Options: DENY\n  orphan\nX-Bad: value\n"
+
+	rules, errs := ParseHeaders(strings.NewReader(file))
+	if len(rules) != 2 {
+		t.Fatalf("ParseHeaders() = %d rules, want 2", len(rules))
+	}
+	if got := rules[0].Headers.Get("Cache-Control"); got != "public, max-age=31536000" {
+		t.Errorf("Cache-Control of /assets/* = %q", got)
+	}
+	if got := rules[1].Headers.Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("X-Frame-Options of /* = %q", got)
+	}
+	// the indented line without a colon is invalid, the unindented one is not a path
+	if len(errs) != 2 {
+		t.Errorf("ParseHeaders() errors = %v, want 2", errs)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	file := strings.Join([]string{
+		"/old/* /:splat",
+		"/go/:name /:name 302",
+		"/search q=:q /:q",
+		"/blog/* /posts/:splat",
+		"/users/:id/posts/:identifier /p/:identifier/:id",
+		"/shadowed /elsewhere",
+		"/forced /elsewhere 301!",
+	}, "\n")
+	rules, errs := ParseRedirects(strings.NewReader(file))
+	if len(errs) != 0 {
+		t.Fatalf("ParseRedirects() errors = %v", errs)
+	}
+	compiled := &Rules{Redirects: rules}
+
+	tests := []struct {
+		path       string
+		query      string
+		fileExists bool
+		want       string
+		ok         bool
+	}{
+		{"/old/page", "", false, "/page", true},
+		{"/old/a/b", "", false, "/a/b", true},
+		{"/old//evil.com", "", false, "/evil.com", true},
+		{"/old///evil.com/x", "", false, "/evil.com/x", true},
+		{"/go/\\evil.com", "", false, "", false},
+		{"/search", "q=/evil.com", false, "", false},
+		{"/search", "q=shoes", false, "/shoes", true},
+		{"/blog", "", false, "/posts/", true},
+		{"/blog//2024//post", "", false, "/posts/2024/post", true},
+		{"/users/7/posts/42", "", false, "/p/42/7", true},
+		{"/shadowed", "", true, "", false},
+		{"/forced", "", true, "/elsewhere", true},
+		{"/unknown", "", false, "", false},
+	}
+
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		_, got, ok := compiled.Match(test.path, query, test.fileExists)
+		if got != test.want || ok != test.ok {
+			t.Errorf("Match(%q, %q) = %q, %v, want %q, %v", test.path, test.query, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestHeadersFor(t *testing.T) {
+	rules, _ := ParseHeaders(strings.NewReader("/*\n  X-Frame-Options: DENY\n/assets/*\n  Cache-Control: immutable\n/about\n  X-Page: about\n"))
+	compiled := &Rules{Headers: rules}
+
+	tests := []struct {
+		path string
+		name string
+		want string
+	}{
+		{"/assets/app.js", "Cache-Control", "immutable"},
+		{"/assets/app.js", "X-Frame-Options", "DENY"},
+		{"/about", "X-Page", "about"},
+		{"/about/team", "X-Page", ""},
+		{"/index.html", "Cache-Control", ""},
+	}
+
+	for _, test := range tests {
+		got := compiled.HeadersFor(test.path).Get(test.name)
+		if got != test.want {
+			t.Errorf("HeadersFor(%q) %s = %q, want %q", test.path, test.name, got, test.want)
+		}
+	}
+}